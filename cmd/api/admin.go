@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/vj-2303/voting-api-go/internal/data"
+	"github.com/vj-2303/voting-api-go/internal/validator"
+)
+
+// newAdminAction builds the audit entry for an action the current user is taking on
+// another user's account. The models record it in the same transaction as the
+// change itself.
+func (app *application) newAdminAction(r *http.Request, targetUserID int64, action string, details map[string]any) *data.AdminAction {
+	admin := app.contextGetUser(r)
+	return &data.AdminAction{
+		AdminID:      &admin.ID,
+		TargetUserID: targetUserID,
+		Action:       action,
+		Details:      details,
+	}
+}
+
+// readTargetUser loads the user named by the :id parameter, writing a 404 if there
+// is no such user. A nil user means a response has already been sent.
+func (app *application) readTargetUser(w http.ResponseWriter, r *http.Request) *data.User {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	user, err := app.models.Users.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return user
+}
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string
+		Email     string
+		Role      string
+		Activated *bool
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Email = app.readString(qs, "email", "")
+	input.Role = app.readString(qs, "role", "")
+	input.Activated = app.readBool(qs, "activated", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	users, metadata, err := app.models.Users.GetAll(r.Context(), input.Name, input.Email, input.Role, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserHandler returns a user along with the audit trail of admin actions taken
+// on their account.
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readTargetUser(w, r)
+	if user == nil {
+		return
+	}
+	actions, err := app.models.AdminActions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "admin_actions": actions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// forceActivateUserHandler activates an account without the user having to follow
+// the link in their activation email.
+func (app *application) forceActivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readTargetUser(w, r)
+	if user == nil {
+		return
+	}
+	if !user.Activated {
+		user.Activated = true
+
+		action := app.newAdminAction(r, user.ID, data.AdminActionActivate, nil)
+		err := app.models.Users.UpdateByAdmin(r.Context(), user, []string{data.ScopeActivation}, action)
+		if err != nil {
+			if errors.Is(err, data.ErrEditConflict) {
+				app.editConflictResponse(w, r)
+			} else {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// suspendUserHandler blocks a user from authenticating and signs them out of every
+// session. An optional reason is kept in the audit trail.
+func (app *application) suspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readTargetUser(w, r)
+	if user == nil {
+		return
+	}
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+	if user.ID == app.contextGetUser(r).ID {
+		app.errorResponse(w, r, http.StatusConflict, "you cannot suspend your own account")
+		return
+	}
+	if !user.IsSuspended() {
+		now := time.Now()
+		user.SuspendedAt = &now
+
+		var details map[string]any
+		if input.Reason != "" {
+			details = map[string]any{"reason": input.Reason}
+		}
+		action := app.newAdminAction(r, user.ID, data.AdminActionSuspend, details)
+		sessions := []string{data.ScopeAuthentication, data.ScopeRefresh}
+
+		err := app.models.Users.UpdateByAdmin(r.Context(), user, sessions, action)
+		if err != nil {
+			if errors.Is(err, data.ErrEditConflict) {
+				app.editConflictResponse(w, r)
+			} else {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readTargetUser(w, r)
+	if user == nil {
+		return
+	}
+	if user.IsSuspended() {
+		user.SuspendedAt = nil
+
+		action := app.newAdminAction(r, user.ID, data.AdminActionUnsuspend, nil)
+		err := app.models.Users.UpdateByAdmin(r.Context(), user, nil, action)
+		if err != nil {
+			if errors.Is(err, data.ErrEditConflict) {
+				app.editConflictResponse(w, r)
+			} else {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteUserHandler erases a user's personal data the same way users can erase
+// their own, so that the polls they created and their ballots keep counting. The
+// audit entry refers to the user by id only.
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readTargetUser(w, r)
+	if user == nil {
+		return
+	}
+	if user.ID == app.contextGetUser(r).ID {
+		app.errorResponse(w, r, http.StatusConflict, "you cannot delete your own account from the admin API")
+		return
+	}
+	action := app.newAdminAction(r, user.ID, data.AdminActionDelete, nil)
+	err := app.models.Users.Pseudonymize(r.Context(), user.ID, action)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vj-2303/voting-api-go/internal/data"
 )
 
 // logError is a generic helper for logging an error message.
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Error(err.Error(), "method", r.Method, "url", r.URL.RequestURI())
+	app.logger.Error(err.Error(), "method", r.Method, "url", r.URL.RequestURI(), "request_id", app.contextGetRequestID(r))
 }
 
 // errorResponse is a generic helper for sending JSON-formatted error messages to the client.
@@ -54,6 +59,16 @@ func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
 
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must include an X-Expected-Version header"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -69,7 +84,69 @@ func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) accountSuspendedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been suspended"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, until time.Time) {
+	retryAfter := int(time.Until(until).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) twoFactorRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must enable two-factor authentication to perform this action"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you do not have permissions to perform this action"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) voteChangeNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this poll does not allow votes to be changed or retracted"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) resultsNotVisibleResponse(w http.ResponseWriter, r *http.Request, poll *data.Poll) {
+	app.errorResponse(w, r, http.StatusForbidden, resultsNotVisibleMessage(poll))
+}
+
+func resultsNotVisibleMessage(poll *data.Poll) string {
+	switch poll.ResultsVisibility {
+	case data.ResultsVisibilityAfterVote:
+		return "results for this poll are only available after you have voted"
+	case data.ResultsVisibilityAfterClose:
+		return "results for this poll are only available after it closes"
+	default:
+		return "results for this poll are only available to its creator"
+	}
+}
+
+func (app *application) pollNotOpenResponse(w http.ResponseWriter, r *http.Request, poll *data.Poll) {
+	now := time.Now()
+	var message string
+	switch {
+	case poll.OpensAt != nil && now.Before(*poll.OpensAt):
+		message = fmt.Sprintf("this poll is not open for voting until %s", poll.OpensAt.Format(time.RFC3339))
+	case poll.ClosesAt != nil && !now.Before(*poll.ClosesAt):
+		message = fmt.Sprintf("this poll closed for voting at %s", poll.ClosesAt.Format(time.RFC3339))
+	default:
+		message = fmt.Sprintf("this poll is not open for voting (status: %s)", poll.Status)
+	}
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
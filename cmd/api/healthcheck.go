@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vj-2303/voting-api-go/internal/data"
 )
 
-func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+// livenessHandler reports that the process is up and serving requests. It checks
+// no dependencies, so that an orchestrator does not restart healthy instances
+// because the database is down.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
 	data := envelope{
 		"status":      "available",
 		"environment": app.config.env,
@@ -15,3 +24,139 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// readinessHandler reports whether the instance should be sent traffic: the database
+// must answer, the schema must be migrated and the background workers must be
+// running. It fails as soon as shutdown begins, so that load balancers stop routing
+// to the instance before the server stops accepting connections.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if app.shuttingDown.Load() {
+		err := app.writeJSON(w, http.StatusServiceUnavailable, envelope{"status": "shutting down"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Probes give up after a second or two, so the checks share one short deadline.
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	ready := true
+	checks := envelope{}
+
+	// Dependency errors are logged rather than returned, as they may contain
+	// connection details.
+	start := time.Now()
+	err := app.models.Health.Ping(ctx)
+	if err != nil {
+		app.logError(r, err)
+		ready = false
+		checks["database"] = envelope{"status": "unavailable"}
+	} else {
+		checks["database"] = envelope{"status": "ok", "latency": time.Since(start).String()}
+	}
+
+	if err == nil {
+		schema, err := app.models.Health.SchemaVersion(ctx)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			ready = false
+			checks["migrations"] = envelope{"status": "not applied"}
+		case err != nil:
+			app.logError(r, err)
+			ready = false
+			checks["migrations"] = envelope{"status": "unavailable"}
+		case schema.Dirty:
+			ready = false
+			checks["migrations"] = envelope{"status": "dirty", "version": schema.Version}
+		default:
+			checks["migrations"] = envelope{"status": "ok", "version": schema.Version}
+		}
+	}
+
+	// A saturated pool is reported but does not fail the check: the instance is
+	// busy rather than broken, and taking it out of rotation would only move its
+	// load onto the others.
+	stats := app.models.Health.Stats()
+	pool := envelope{
+		"open":          stats.OpenConnections,
+		"in_use":        stats.InUse,
+		"idle":          stats.Idle,
+		"max_open":      stats.MaxOpenConnections,
+		"wait_count":    stats.WaitCount,
+		"wait_duration": stats.WaitDuration.String(),
+	}
+	if stats.MaxOpenConnections > 0 {
+		pool["saturation"] = float64(stats.InUse) / float64(stats.MaxOpenConnections)
+	}
+	checks["pool"] = pool
+
+	workers, ok := app.workers.check()
+	if !ok {
+		ready = false
+	}
+	checks["workers"] = workers
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	err = app.writeJSON(w, code, envelope{"status": status, "checks": checks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// workerMonitor tracks when each background worker last completed a run, so that a
+// worker which has stopped or hung makes the instance unready.
+type workerMonitor struct {
+	mu      sync.Mutex
+	workers map[string]*workerStatus
+}
+
+type workerStatus struct {
+	interval time.Duration
+	lastRun  time.Time
+}
+
+func newWorkerMonitor() *workerMonitor {
+	return &workerMonitor{workers: make(map[string]*workerStatus)}
+}
+
+// register adds a worker that runs every interval, counting it as having just run.
+func (m *workerMonitor) register(name string, interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.workers[name] = &workerStatus{interval: interval, lastRun: time.Now()}
+}
+
+// beat records that a worker has completed a run.
+func (m *workerMonitor) beat(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.workers[name]; ok {
+		s.lastRun = time.Now()
+	}
+}
+
+// check reports the state of every worker, and whether they all ran recently. A
+// worker is stalled once three of its intervals pass without a run.
+func (m *workerMonitor) check() (envelope, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	healthy := true
+	workers := envelope{}
+	for name, s := range m.workers {
+		status := "ok"
+		if time.Since(s.lastRun) > 3*s.interval {
+			status = "stalled"
+			healthy = false
+		}
+		workers[name] = envelope{"status": status, "last_run": s.lastRun}
+	}
+	return workers, healthy
+}
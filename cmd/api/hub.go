@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+)
+
+// resultsHub is an in-process publish/subscribe hub that tells streaming clients when
+// the results of a poll they are watching may have changed. Notifications carry no
+// payload: subscribers re-read the results themselves, so a burst of votes collapses
+// into a single pending notification per subscriber.
+type resultsHub struct {
+	mu          sync.Mutex
+	subscribers map[int64]map[chan struct{}]struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func newResultsHub() *resultsHub {
+	return &resultsHub{
+		subscribers: make(map[int64]map[chan struct{}]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// subscribe registers interest in a poll. The returned function must be called to
+// release the subscription once the client goes away.
+func (h *resultsHub) subscribe(pollID int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	h.mu.Lock()
+	if h.subscribers[pollID] == nil {
+		h.subscribers[pollID] = make(map[chan struct{}]struct{})
+	}
+	h.subscribers[pollID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers[pollID], ch)
+		if len(h.subscribers[pollID]) == 0 {
+			delete(h.subscribers, pollID)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// publish notifies every subscriber of a poll without blocking. A subscriber that
+// already has a notification pending does not need another one.
+func (h *resultsHub) publish(pollID int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers[pollID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// close signals every open stream to finish. It is safe to call more than once.
+func (h *resultsHub) close() {
+	h.closeOnce.Do(func() {
+		close(h.done)
+	})
+}
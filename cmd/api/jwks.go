@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/http"
+)
+
+// jwksHandler publishes the public keys used to sign JWTs, so that other services
+// can verify tokens issued by this API.
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"keys": app.keys.JWKS()}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/vj-2303/voting-api-go/internal/data"
+)
+
+// loginKeys returns the keys that failed logins for the email address from this
+// request are tracked under: one for the account and one for the client.
+func (app *application) loginKeys(r *http.Request, email string) (account, client string) {
+	return data.LoginEmailKey(email), data.LoginIPKey(app.clientIP(r))
+}
+
+// lockoutDuration returns how long to lock a key out for after the given number of
+// failures, or zero if it is still below the threshold. Each failure past the
+// threshold doubles the lockout, up to the configured maximum.
+func (app *application) lockoutDuration(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	d := app.config.login.lockout
+	for i := threshold; i < failures && d < app.config.login.maxLockout; i++ {
+		d *= 2
+	}
+	return min(d, app.config.login.maxLockout)
+}
+
+// recordLoginFailure counts a failed login against the account and the client,
+// locking out whichever of them has now failed too often.
+func (app *application) recordLoginFailure(ctx context.Context, account, client string) error {
+	app.metrics.failedLogins.Inc()
+
+	thresholds := map[string]int{
+		account: app.config.login.maxAttempts,
+		client:  app.config.login.ipMaxAttempts,
+	}
+	for key, threshold := range thresholds {
+		failures, err := app.models.LoginAttempts.RecordFailure(ctx, key)
+		if err != nil {
+			return err
+		}
+		if d := app.lockoutDuration(failures, threshold); d > 0 {
+			app.logger.Warn("locking out after failed logins", "key", key, "failures", failures, "duration", d)
+
+			err = app.models.LoginAttempts.Lock(ctx, key, time.Now().Add(d))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listLockoutsHandler shows the accounts and clients currently locked out after too
+// many failed logins.
+func (app *application) listLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := app.models.LoginAttempts.GetLocked(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"lockouts": lockouts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unlockUserHandler clears the failed logins recorded against a user's account.
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readTargetUser(w, r)
+	if user == nil {
+		return
+	}
+	action := app.newAdminAction(r, user.ID, data.AdminActionUnlock, nil)
+	err := app.models.LoginAttempts.Unlock(r.Context(), data.LoginEmailKey(user.Email), action)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account successfully unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,17 +3,26 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/vj-2303/voting-api-go/internal/data"
+	"github.com/vj-2303/voting-api-go/internal/keyring"
+	"github.com/vj-2303/voting-api-go/internal/mailer"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
 )
 
 const version = "1.0.0"
@@ -21,21 +30,85 @@ const version = "1.0.0"
 type config struct {
 	port int
 	env  string
-	db   struct {
+	// trustedProxies lists the reverse proxies whose X-Forwarded-For headers are
+	// believed when working out a client's address.
+	trustedProxies []netip.Prefix
+	db             struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  time.Duration
 	}
 	jwt struct {
-		secret string
+		secret     string
+		keys       string
+		signingKID string
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+	scheduler struct {
+		interval time.Duration
+	}
+	sse struct {
+		heartbeat time.Duration
+	}
+	mailer struct {
+		kind string
+		dir  string
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	twoFactor struct {
+		issuer           string
+		requireForAdmins bool
+	}
+	login struct {
+		maxAttempts   int
+		ipMaxAttempts int
+		lockout       time.Duration
+		maxLockout    time.Duration
+	}
+	limiter struct {
+		enabled     bool
+		rps         float64
+		burst       int
+		idleTimeout time.Duration
+	}
+	metrics struct {
+		addr     string
+		username string
+		password string
+	}
+	otel struct {
+		exporter    string
+		endpoint    string
+		sampleRatio float64
+	}
+	shutdown struct {
+		drainDelay time.Duration
 	}
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
+	config     config
+	logger     *slog.Logger
+	models     data.Models
+	keys       *keyring.Keyring
+	mailer     mailer.Mailer
+	resultsHub *resultsHub
+	metrics    *appMetrics
+	// rateLimiters holds every limiter created while building the routes, for
+	// idle client eviction.
+	rateLimiters []*rateLimiter
+	workers      *workerMonitor
+	// shuttingDown is set once a shutdown signal arrives, failing readiness checks.
+	shuttingDown atomic.Bool
+	wg           sync.WaitGroup
 }
 
 func main() {
@@ -50,12 +123,88 @@ func main() {
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
 
-	flag.StringVar(&cfg.jwt.secret, "jwt-secret", "", "JWT secret key")
+	flag.StringVar(&cfg.jwt.secret, "jwt-secret", "", "JWT HMAC secret key, at least 32 bytes (key id \"hmac\")")
+	flag.StringVar(&cfg.jwt.keys, "jwt-keys", "", "Comma-separated kid=path list of PEM RSA/Ed25519 keys trusted for JWTs")
+	flag.StringVar(&cfg.jwt.signingKID, "jwt-signing-kid", "", "Key id used to sign new JWTs (default \"hmac\" if -jwt-secret is set)")
+	flag.DurationVar(&cfg.jwt.accessTTL, "jwt-access-ttl", 15*time.Minute, "Lifetime of access (authentication) tokens")
+	flag.DurationVar(&cfg.jwt.refreshTTL, "jwt-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+
+	flag.DurationVar(&cfg.scheduler.interval, "scheduler-interval", 30*time.Second, "Interval between poll state transition checks")
+
+	flag.DurationVar(&cfg.sse.heartbeat, "sse-heartbeat", 15*time.Second, "Interval between heartbeats on results streams")
+
+	flag.StringVar(&cfg.mailer.kind, "mailer", "smtp", "Mailer implementation (smtp|log); log is for local development only")
+	flag.StringVar(&cfg.mailer.dir, "mailer-dir", "", "Directory the log mailer writes .eml files to (optional)")
+
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Voting API <no-reply@voting-api.local>", "SMTP sender")
+
+	flag.StringVar(&cfg.twoFactor.issuer, "2fa-issuer", "Voting API", "Issuer name shown in authenticator apps")
+	flag.BoolVar(&cfg.twoFactor.requireForAdmins, "2fa-require-admin", false, "Require two-factor authentication for users who can manage users or roles")
+
+	flag.IntVar(&cfg.login.maxAttempts, "login-max-attempts", 5, "Failed logins for an account before it is locked out")
+	flag.IntVar(&cfg.login.ipMaxAttempts, "login-ip-max-attempts", 20, "Failed logins from a client address before it is locked out")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", time.Minute, "Initial lockout after too many failed logins, doubled for each further failure")
+	flag.DurationVar(&cfg.login.maxLockout, "login-max-lockout", time.Hour, "Maximum lockout after failed logins")
+
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiting")
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 4, "Rate limiter maximum requests per second per client")
+	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 8, "Rate limiter maximum burst per client")
+	flag.DurationVar(&cfg.limiter.idleTimeout, "limiter-idle-timeout", 3*time.Minute, "Time after which an idle client's rate limit state is discarded")
+
+	flag.StringVar(&cfg.metrics.addr, "metrics-addr", "", "Separate listen address for /metrics, such as 127.0.0.1:9090")
+	flag.StringVar(&cfg.metrics.username, "metrics-username", "", "Basic auth username for /metrics on the main listener")
+	flag.StringVar(&cfg.metrics.password, "metrics-password", "", "Basic auth password for /metrics on the main listener")
+
+	flag.StringVar(&cfg.otel.exporter, "otel-exporter", "none", "Trace exporter (none|stdout|otlp)")
+	flag.StringVar(&cfg.otel.endpoint, "otel-endpoint", "", "OTLP/HTTP endpoint URL for traces, such as http://localhost:4318")
+	flag.Float64Var(&cfg.otel.sampleRatio, "otel-sample-ratio", 1, "Fraction of new traces to sample")
+
+	flag.DurationVar(&cfg.shutdown.drainDelay, "shutdown-drain-delay", 5*time.Second, "Time between SIGTERM and server shutdown, for load balancers to stop sending traffic")
+
+	flag.Func("trusted-proxies", "Comma-separated IP addresses or CIDR ranges of trusted reverse proxies", func(val string) error {
+		for _, s := range strings.Split(val, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(s)
+				if addrErr != nil {
+					return err
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			cfg.trustedProxies = append(cfg.trustedProxies, prefix.Masked())
+		}
+		return nil
+	})
 
 	flag.Parse()
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
+	if cfg.sse.heartbeat <= 0 {
+		logger.Error(fmt.Sprintf("invalid -sse-heartbeat %s, must be positive", cfg.sse.heartbeat))
+		os.Exit(1)
+	}
+
+	keys, err := openKeyring(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	shutdownTracer, err := openTracer(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.Error(err.Error())
@@ -65,35 +214,102 @@ func main() {
 
 	logger.Info("database connection pool established")
 
+	var m mailer.Mailer
+	switch cfg.mailer.kind {
+	case "smtp":
+		m, err = mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	case "log":
+		m = mailer.NewLog(logger, cfg.mailer.dir, cfg.smtp.sender)
+	default:
+		logger.Error(fmt.Sprintf("unknown mailer %q", cfg.mailer.kind))
+		os.Exit(1)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:     cfg,
+		logger:     logger,
+		models:     data.NewModels(db),
+		keys:       keys,
+		mailer:     m,
+		resultsHub: newResultsHub(),
+		metrics:    newAppMetrics(db),
+		workers:    newWorkerMonitor(),
 	}
 
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.authenticate(app.routes()),
+		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 	}
+	// Open result streams never go idle on their own, so end them as soon as
+	// shutdown starts rather than waiting for the shutdown timeout.
+	srv.RegisterOnShutdown(app.resultsHub.close)
+
+	// The workers start once the routes, and with them all the rate limiters, have
+	// been built.
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	app.wg.Add(1)
+	go app.runPollScheduler(schedulerCtx, cfg.scheduler.interval)
+	app.wg.Add(1)
+	go app.runTokenCleanup(schedulerCtx, time.Hour)
+	app.wg.Add(1)
+	go app.runRateLimiterEviction(schedulerCtx, time.Minute)
 
 	go func() {
 		logger.Info("starting server", "addr", srv.Addr, "env", cfg.env)
 		err := srv.ListenAndServe()
-		if err != nil {
+		// ErrServerClosed is the expected result of srv.Shutdown below; exiting
+		// here would cut the graceful shutdown short.
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error(err.Error())
 			os.Exit(1)
 		}
 	}()
 
+	// Metrics are never served publicly: either on their own listener, which should
+	// be bound to a private address, or on the main listener behind basic auth.
+	var metricsSrv *http.Server
+	if cfg.metrics.addr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /metrics", app.metricsHandler)
+
+		metricsSrv = &http.Server{
+			Addr:         cfg.metrics.addr,
+			Handler:      mux,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		}
+		go func() {
+			logger.Info("starting metrics server", "addr", metricsSrv.Addr)
+			err := metricsSrv.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+		}()
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
-	<-quit
-	logger.Info("shutting down server...")
+	sig := <-quit
+	app.shuttingDown.Store(true)
+	logger.Info("shutting down server...", "signal", sig.String())
+
+	// SIGTERM comes from an orchestrator, which keeps routing requests here until
+	// its readiness probes notice the failure. Keep serving them in the meantime.
+	if sig == syscall.SIGTERM && cfg.shutdown.drainDelay > 0 {
+		logger.Info("draining traffic", "delay", cfg.shutdown.drainDelay.String())
+		time.Sleep(cfg.shutdown.drainDelay)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -102,11 +318,30 @@ func main() {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	logger.Info("stopping background workers...")
+	stopScheduler()
+	app.wg.Wait()
+
+	if err := shutdownTracer(ctx); err != nil {
+		logger.Error(err.Error())
+	}
+
 	logger.Info("server stopped")
 }
 
 func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("pgx", cfg.db.dsn)
+	// Every query gets a span as a child of the request's span, found through the
+	// context passed to the model methods.
+	db, err := otelsql.Open("pgx", cfg.db.dsn,
+		otelsql.WithAttributes(semconv.DBSystemNamePostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -123,3 +358,38 @@ func openDB(cfg config) (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+// openKeyring loads the JWT keys named by the -jwt-secret and -jwt-keys flags. It
+// refuses to run without a signing key or with a weak HMAC secret.
+func openKeyring(cfg config) (*keyring.Keyring, error) {
+	var keys []*keyring.Key
+
+	if cfg.jwt.secret != "" {
+		key, err := keyring.NewHMAC("hmac", cfg.jwt.secret)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	for _, entry := range strings.Split(cfg.jwt.keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(entry, "=")
+		if !ok || kid == "" || path == "" {
+			return nil, fmt.Errorf("invalid -jwt-keys entry %q, expected kid=path", entry)
+		}
+		key, err := keyring.LoadPEM(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	signingKID := cfg.jwt.signingKID
+	if signingKID == "" && cfg.jwt.secret != "" {
+		signingKID = "hmac"
+	}
+	return keyring.New(signingKID, keys...)
+}
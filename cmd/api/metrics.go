@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vj-2303/voting-api-go/internal/metrics"
+)
+
+// appMetrics holds the metrics the API reports at /metrics.
+type appMetrics struct {
+	registry *metrics.Registry
+
+	requests        *metrics.CounterVec
+	requestDuration *metrics.HistogramVec
+	votesCast       *metrics.Counter
+	duplicateVotes  *metrics.Counter
+	pollsCreated    *metrics.Counter
+	failedLogins    *metrics.Counter
+}
+
+func newAppMetrics(db *sql.DB) *appMetrics {
+	reg := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry:        reg,
+		requests:        reg.NewCounterVec("http_requests_total", "Total HTTP requests by method, route and status.", "method", "route", "status"),
+		requestDuration: reg.NewHistogramVec("http_request_duration_seconds", "HTTP request latency by method and route.", metrics.DefaultBuckets, "method", "route"),
+		votesCast:       reg.NewCounterVec("votes_cast_total", "Ballots cast on polls.").With(),
+		duplicateVotes:  reg.NewCounterVec("votes_duplicate_rejected_total", "Ballots rejected because the user had already voted.").With(),
+		pollsCreated:    reg.NewCounterVec("polls_created_total", "Polls created.").With(),
+		failedLogins:    reg.NewCounterVec("logins_failed_total", "Login attempts rejected for a wrong password or second factor.").With(),
+	}
+
+	if db != nil {
+		stat := func(fn func(s sql.DBStats) float64) func() float64 {
+			return func() float64 { return fn(db.Stats()) }
+		}
+		reg.NewGaugeFunc("db_max_open_connections", "Maximum number of open database connections.", stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+		reg.NewGaugeFunc("db_open_connections", "Established database connections, in use and idle.", stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+		reg.NewGaugeFunc("db_in_use_connections", "Database connections currently in use.", stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+		reg.NewGaugeFunc("db_idle_connections", "Idle database connections.", stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+		reg.NewCounterFunc("db_wait_count_total", "Total times a goroutine waited for a database connection.", stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+		reg.NewCounterFunc("db_wait_duration_seconds_total", "Total time spent waiting for a database connection.", stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+		reg.NewCounterFunc("db_max_idle_closed_total", "Connections closed because of the idle connection limit.", stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+		reg.NewCounterFunc("db_max_idle_time_closed_total", "Connections closed because of the idle time limit.", stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	}
+	return m
+}
+
+// observeRequest records a served request in the request counter and histogram.
+// Clients choose the method and path, so only known values are used as labels:
+// anything else would let them create series without limit.
+func (m *appMetrics) observeRequest(method, route string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	switch {
+	case route == "unmatched":
+		method = ""
+	case !standardMethods[method]:
+		method = "OTHER"
+	}
+	m.requests.With(method, route, strconv.Itoa(status)).Inc()
+	m.requestDuration.With(method, route).Observe(duration.Seconds())
+}
+
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	_, err := app.metrics.registry.WriteTo(w)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// requireMetricsAuth protects the metrics endpoint with HTTP basic authentication
+// using the configured credentials. The hashes are compared in constant time.
+func (app *application) requireMetricsAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if ok {
+			usernameHash := sha256.Sum256([]byte(username))
+			passwordHash := sha256.Sum256([]byte(password))
+			expectedUsernameHash := sha256.Sum256([]byte(app.config.metrics.username))
+			expectedPasswordHash := sha256.Sum256([]byte(app.config.metrics.password))
+
+			usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1
+			passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1
+
+			if usernameMatch && passwordMatch {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+		app.errorResponse(w, r, http.StatusUnauthorized, "invalid or missing metrics credentials")
+	}
+}
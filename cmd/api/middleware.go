@@ -2,20 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/julienschmidt/httprouter"
 	"github.com/vj-2303/voting-api-go/internal/data"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey      = contextKey("user")
+	tokenContextKey     = contextKey("token")
+	requestIDContextKey = contextKey("requestID")
+	accessLogContextKey = contextKey("accessLog")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	// The access log is written by an outer middleware that never sees this
+	// request value, so hand it the user directly.
+	if entry, ok := r.Context().Value(accessLogContextKey).(*accessLogEntry); ok && !user.IsAnonymous() {
+		entry.userID = user.ID
+	}
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -28,6 +43,175 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	return user
 }
 
+// contextSetToken stores the authentication token used for the request, so that it
+// can be revoked on logout.
+func (app *application) contextSetToken(r *http.Request, token *data.Token) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetToken(r *http.Request) *data.Token {
+	token, ok := r.Context().Value(tokenContextKey).(*data.Token)
+	if !ok {
+		panic("missing token value in request context")
+	}
+	return token
+}
+
+// contextGetRequestID returns the request's ID, or an empty string outside of the
+// requestID middleware.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// requestID tags each request with an ID, echoed in the X-Request-ID response
+// header and attached to log lines. An ID sent by the client or an upstream proxy is
+// kept if it looks sane, so that a request can be traced across services.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// recoverPanic turns a panic in a handler into a 500 response, rather than letting
+// net/http abort the connection.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				// ErrAbortHandler is net/http's sanctioned way to abort a response.
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				w.Header().Set("Connection", "close")
+				app.serverErrorResponse(w, r, fmt.Errorf("%v", err))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// accessLogEntry collects what the access log needs to know about a request from the
+// middleware and handlers further down the chain.
+type accessLogEntry struct {
+	userID int64
+}
+
+// accessLogResponseWriter records the status code and size of a response. It
+// implements Unwrap so that http.ResponseController, used by the results stream,
+// can still reach the underlying connection.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	bytes       int
+	wroteHeader bool
+}
+
+func (mw *accessLogResponseWriter) WriteHeader(statusCode int) {
+	if !mw.wroteHeader {
+		mw.statusCode = statusCode
+		mw.wroteHeader = true
+	}
+	mw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (mw *accessLogResponseWriter) Write(b []byte) (int, error) {
+	if !mw.wroteHeader {
+		mw.WriteHeader(http.StatusOK)
+	}
+	n, err := mw.ResponseWriter.Write(b)
+	mw.bytes += n
+	return n, err
+}
+
+// FlushError flushes the underlying writer, noting that the headers have been sent
+// with the default status.
+func (mw *accessLogResponseWriter) FlushError() error {
+	mw.wroteHeader = true
+	return http.NewResponseController(mw.ResponseWriter).Flush()
+}
+
+func (mw *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
+
+// logAccess writes one structured log line per request once it has been served,
+// records the request in the HTTP metrics and completes its trace span.
+func (app *application) logAccess(router *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		entry := &accessLogEntry{}
+		mw := &accessLogResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		ctx := context.WithValue(r.Context(), accessLogContextKey, entry)
+		next.ServeHTTP(mw, r.WithContext(ctx))
+
+		duration := time.Since(start)
+		route := routePattern(router, r)
+		app.metrics.observeRequest(r.Method, route, mw.statusCode, duration)
+		endRequestSpan(r, route, mw.statusCode)
+
+		attrs := []any{
+			"method", r.Method,
+			"route", route,
+			"status", mw.statusCode,
+			"bytes", mw.bytes,
+			"duration", duration,
+			"request_id", app.contextGetRequestID(r),
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+			attrs = append(attrs, "trace_id", sc.TraceID().String())
+		}
+		if entry.userID != 0 {
+			attrs = append(attrs, "user_id", entry.userID)
+		}
+		app.logger.Info("request", attrs...)
+	})
+}
+
+// routePattern returns the route the request matched, such as /v1/polls/:id, so that
+// requests for different resources are logged under the same route. Unmatched
+// requests are reported as "unmatched" to keep arbitrary paths out of the logs.
+func routePattern(router *httprouter.Router, r *http.Request) string {
+	handle, params, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "unmatched"
+	}
+	segments := strings.Split(r.URL.Path, "/")
+	next := 0
+	for _, param := range params {
+		for i := next; i < len(segments); i++ {
+			if segments[i] == param.Value {
+				segments[i] = ":" + param.Key
+				next = i + 1
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
@@ -48,13 +232,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		tokenString := headerParts[1]
 
 		claims := jwt.MapClaims{}
-		_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
-			// Check the signing algorithm.
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return []byte(app.config.jwt.secret), nil
-		})
+		_, err := jwt.ParseWithClaims(tokenString, claims, app.keys.Keyfunc, jwt.WithValidMethods(app.keys.Algorithms()))
 		if err != nil {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -73,7 +251,14 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
-		user, err := app.models.Users.GetByID(userID)
+		// A valid signature is not enough: the token's jti must still be on record,
+		// otherwise it has been revoked.
+		jti, ok := claims["jti"].(string)
+		if !ok || jti == "" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, jti)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -83,6 +268,23 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		if user.ID != userID {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		if user.IsSuspended() {
+			app.accountSuspendedResponse(w, r)
+			return
+		}
+		token := &data.Token{
+			Hash:   data.TokenHash(jti),
+			UserID: user.ID,
+			Scope:  data.ScopeAuthentication,
+		}
+		if family, ok := claims["fam"].(string); ok {
+			token.Family = family
+		}
+		r = app.contextSetToken(r, token)
 		r = app.contextSetUser(r, user)
 		next.ServeHTTP(w, r)
 	})
@@ -100,13 +302,41 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	})
 }
 
-func (app *application) requireAdminUser(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
-		if user.Role != "admin" {
-			app.notPermittedResponse(w, r)
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
+	return app.requireAuthenticatedUser(fn)
+}
+
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		missing, err := app.twoFactorMissing(r.Context(), user.ID, permissions)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if missing {
+			app.twoFactorRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return app.requireActivatedUser(fn)
 }
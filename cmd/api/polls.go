@@ -3,6 +3,8 @@ package main
 import (
 	"errors"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/vj-2303/voting-api-go/internal/data"
 	"github.com/vj-2303/voting-api-go/internal/validator"
@@ -11,9 +13,19 @@ import (
 func (app *application) createPollHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
-		Title       string   `json:"title"`
-		Description string   `json:"description"`
-		Options     []string `json:"options"`
+		Title             string     `json:"title"`
+		Description       string     `json:"description"`
+		Options           []string   `json:"options"`
+		VotingMode        string     `json:"voting_mode"`
+		MinChoices        *int       `json:"min_choices"`
+		MaxChoices        *int       `json:"max_choices"`
+		ScoreMin          *int       `json:"score_min"`
+		ScoreMax          *int       `json:"score_max"`
+		AllowVoteChange   bool       `json:"allow_vote_change"`
+		ResultsVisibility string     `json:"results_visibility"`
+		Status            string     `json:"status"`
+		OpensAt           *time.Time `json:"opens_at"`
+		ClosesAt          *time.Time `json:"closes_at"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -23,21 +35,64 @@ func (app *application) createPollHandler(w http.ResponseWriter, r *http.Request
 	user := app.contextGetUser(r)
 
 	poll := &data.Poll{
-		Title:       input.Title,
-		Description: input.Description,
-		Options:     input.Options,
-		CreatedBy:   user.ID,
+		Title:             input.Title,
+		Description:       input.Description,
+		Options:           input.Options,
+		VotingMode:        input.VotingMode,
+		MinChoices:        1,
+		MaxChoices:        1,
+		ScoreMin:          input.ScoreMin,
+		ScoreMax:          input.ScoreMax,
+		Status:            input.Status,
+		AllowVoteChange:   input.AllowVoteChange,
+		ResultsVisibility: input.ResultsVisibility,
+		OpensAt:           input.OpensAt,
+		ClosesAt:          input.ClosesAt,
+		CreatedBy:         user.ID,
+	}
+	if poll.VotingMode == "" {
+		poll.VotingMode = data.VotingModeSingle
+	}
+	if poll.VotingMode == data.VotingModeMultiple || poll.VotingMode == data.VotingModeRanked {
+		// Default to plain approval voting, or a full ranking for ranked polls:
+		// any number of options may be chosen.
+		poll.MaxChoices = len(poll.Options)
+	}
+	if poll.VotingMode == data.VotingModeScore {
+		// Every option must be scored, on a 0-5 scale unless told otherwise.
+		poll.MinChoices = len(poll.Options)
+		poll.MaxChoices = len(poll.Options)
+		if poll.ScoreMin == nil {
+			poll.ScoreMin = new(int)
+		}
+		if poll.ScoreMax == nil {
+			scoreMax := 5
+			poll.ScoreMax = &scoreMax
+		}
+	}
+	if input.MinChoices != nil {
+		poll.MinChoices = *input.MinChoices
+	}
+	if input.MaxChoices != nil {
+		poll.MaxChoices = *input.MaxChoices
+	}
+	if poll.ResultsVisibility == "" {
+		poll.ResultsVisibility = data.ResultsVisibilityPrivate
+	}
+	if poll.Status == "" {
+		poll.Status = poll.InitialStatus(time.Now())
 	}
 	v := validator.New()
 	if data.ValidatePoll(v, poll); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.Polls.Insert(poll)
+	err = app.models.Polls.Insert(r.Context(), poll)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.metrics.pollsCreated.Inc()
 	err = app.writeJSON(w, http.StatusCreated, envelope{"poll": poll}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -48,8 +103,9 @@ func (app *application) showPollHandler(w http.ResponseWriter, r *http.Request)
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
-	poll, err := app.models.Polls.GetByID(id)
+	poll, err := app.models.Polls.GetByID(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.notFoundResponse(w, r)
@@ -64,13 +120,13 @@ func (app *application) showPollHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-func (app *application) castVoteHandler(w http.ResponseWriter, r *http.Request) {
+func (app *application) updatePollHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
-	poll, err := app.models.Polls.GetByID(id)
+	poll, err := app.models.Polls.GetByID(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.notFoundResponse(w, r)
@@ -80,35 +136,216 @@ func (app *application) castVoteHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	var input struct {
-		Option string `json:"option"`
+		Title             *string    `json:"title"`
+		Description       *string    `json:"description"`
+		Options           []string   `json:"options"`
+		VotingMode        *string    `json:"voting_mode"`
+		MinChoices        *int       `json:"min_choices"`
+		MaxChoices        *int       `json:"max_choices"`
+		ScoreMin          *int       `json:"score_min"`
+		ScoreMax          *int       `json:"score_max"`
+		AllowVoteChange   *bool      `json:"allow_vote_change"`
+		ResultsVisibility *string    `json:"results_visibility"`
+		Status            *string    `json:"status"`
+		OpensAt           *time.Time `json:"opens_at"`
+		ClosesAt          *time.Time `json:"closes_at"`
 	}
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	if input.Title != nil {
+		poll.Title = *input.Title
+	}
+	if input.Description != nil {
+		poll.Description = *input.Description
+	}
+	if input.AllowVoteChange != nil {
+		poll.AllowVoteChange = *input.AllowVoteChange
+	}
+	if input.ResultsVisibility != nil {
+		poll.ResultsVisibility = *input.ResultsVisibility
+	}
+	if input.Status != nil {
+		poll.Status = *input.Status
+	}
+	if input.OpensAt != nil {
+		poll.OpensAt = input.OpensAt
+	}
+	if input.ClosesAt != nil {
+		poll.ClosesAt = input.ClosesAt
+	}
+
+	// Renaming, adding or removing options, or changing the ballot rules, after votes
+	// have been cast would leave existing ballots invalid.
+	ballotChanged := false
+	if input.Options != nil && !slices.Equal(input.Options, poll.Options) {
+		poll.Options = input.Options
+		ballotChanged = true
+	}
+	if input.VotingMode != nil && *input.VotingMode != poll.VotingMode {
+		poll.VotingMode = *input.VotingMode
+		ballotChanged = true
+	}
+	if input.MinChoices != nil && *input.MinChoices != poll.MinChoices {
+		poll.MinChoices = *input.MinChoices
+		ballotChanged = true
+	}
+	if input.MaxChoices != nil && *input.MaxChoices != poll.MaxChoices {
+		poll.MaxChoices = *input.MaxChoices
+		ballotChanged = true
+	}
+	if input.ScoreMin != nil && (poll.ScoreMin == nil || *input.ScoreMin != *poll.ScoreMin) {
+		poll.ScoreMin = input.ScoreMin
+		ballotChanged = true
+	}
+	if input.ScoreMax != nil && (poll.ScoreMax == nil || *input.ScoreMax != *poll.ScoreMax) {
+		poll.ScoreMax = input.ScoreMax
+		ballotChanged = true
+	}
+	if ballotChanged {
+		hasVotes, err := app.models.Votes.HasVotes(r.Context(), poll.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if hasVotes {
+			app.errorResponse(w, r, http.StatusConflict, "options and voting rules cannot be changed once votes have been cast")
+			return
+		}
+	}
 
 	v := validator.New()
-	if data.ValidateVote(v, input.Option, poll.Options); !v.Valid() {
+	if data.ValidatePoll(v, poll); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	err = app.models.Polls.Update(r.Context(), poll)
+	if err != nil {
+		if errors.Is(err, data.ErrEditConflict) {
+			app.editConflictResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"poll": poll}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePollHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Polls.Delete(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "poll successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPollsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title       string
+		CreatedBy   int
+		Status      string
+		CreatedFrom *time.Time
+		CreatedTo   *time.Time
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.CreatedBy = app.readInt(qs, "created_by", 0, v)
+	input.Status = app.readString(qs, "status", "")
+	input.CreatedFrom = app.readTime(qs, "created_from", v)
+	input.CreatedTo = app.readTime(qs, "created_to", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "title", "created_at", "-id", "-title", "-created_at"}
+
+	v.Check(input.CreatedBy >= 0, "created_by", "must not be negative")
+	if input.Status != "" {
+		v.Check(validator.In(input.Status, data.PollStatuses...), "status", "must be one of draft, scheduled, open, closed or archived")
+	}
+	if input.CreatedFrom != nil && input.CreatedTo != nil {
+		v.Check(input.CreatedFrom.Before(*input.CreatedTo), "created_to", "must be after created_from")
+	}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	polls, metadata, err := app.models.Polls.GetAll(r.Context(), input.Title, int64(input.CreatedBy), input.Status, input.CreatedFrom, input.CreatedTo, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"polls": polls, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) castVoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	poll, err := app.models.Polls.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !poll.AcceptsVotes(time.Now()) {
+		app.pollNotOpenResponse(w, r, poll)
+		return
+	}
 	user := app.contextGetUser(r)
 
-	vote := &data.Vote{
-		PollID:       poll.ID,
-		UserID:       user.ID,
-		ChosenOption: input.Option,
+	vote, err := app.readBallot(w, r, poll.ID, user.ID)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateVote(v, vote, poll); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
-	err = app.models.Votes.Insert(vote)
+	err = app.models.Votes.Insert(r.Context(), vote)
 	if err != nil {
 		if errors.Is(err, data.ErrDuplicateVote) {
+			app.metrics.duplicateVotes.Inc()
 			app.errorResponse(w, r, http.StatusConflict, "you have already voted on this poll")
 		} else {
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	app.metrics.votesCast.Inc()
+	app.resultsHub.publish(poll.ID)
+
 	err = app.writeJSON(w, http.StatusCreated, envelope{"vote": vote}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -122,7 +359,7 @@ func (app *application) showPollResultsHandler(w http.ResponseWriter, r *http.Re
 		app.notFoundResponse(w, r)
 		return
 	}
-	poll, err := app.models.Polls.GetWithResults(id)
+	poll, err := app.models.Polls.GetByID(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -132,8 +369,61 @@ func (app *application) showPollResultsHandler(w http.ResponseWriter, r *http.Re
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"poll": poll}, nil)
+	allowed, err := app.canViewResults(r, poll)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		app.resultsNotVisibleResponse(w, r, poll)
+		return
+	}
+	results, err := app.models.Polls.Tally(r.Context(), poll)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"poll": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// canViewResults applies the poll's results visibility policy to the current user.
+// The poll's creator and users with the polls:results:read permission can always see
+// the results.
+func (app *application) canViewResults(r *http.Request, poll *data.Poll) (bool, error) {
+	user := app.contextGetUser(r)
+
+	if !user.IsAnonymous() {
+		if user.ID == poll.CreatedBy {
+			return true, nil
+		}
+		permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			return false, err
+		}
+		if permissions.Include(data.PermissionPollsResultsRead) {
+			missing, err := app.twoFactorMissing(r.Context(), user.ID, permissions)
+			if err != nil {
+				return false, err
+			}
+			if !missing {
+				return true, nil
+			}
+		}
+	}
+	switch poll.ResultsVisibility {
+	case data.ResultsVisibilityPublic:
+		return true, nil
+	case data.ResultsVisibilityAfterClose:
+		return poll.IsClosed(time.Now()), nil
+	case data.ResultsVisibilityAfterVote:
+		if user.IsAnonymous() {
+			return false, nil
+		}
+		return app.models.Votes.HasVoted(r.Context(), poll.ID, user.ID)
+	default:
+		return false, nil
 	}
 }
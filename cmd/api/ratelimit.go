@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter holds a token bucket per client. Clients are identified by user ID
+// when authenticated and by IP address otherwise, so that users behind a shared
+// address do not use up each other's allowance.
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	clients map[string]*rateLimitClient
+}
+
+type rateLimitClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRateLimiter creates a limiter allowing limit requests per second per client,
+// with bursts of up to burst requests, and registers it for idle bucket eviction.
+func (app *application) newRateLimiter(limit rate.Limit, burst int) *rateLimiter {
+	l := &rateLimiter{
+		limit:   limit,
+		burst:   burst,
+		clients: make(map[string]*rateLimitClient),
+	}
+	app.rateLimiters = append(app.rateLimiters, l)
+	return l
+}
+
+// rateLimitStatus describes a client's bucket after a request, for the RateLimit
+// response headers.
+type rateLimitStatus struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+func (l *rateLimiter) allow(key string) rateLimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &rateLimitClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+
+	status := rateLimitStatus{allowed: c.limiter.AllowN(now, 1)}
+
+	tokens := c.limiter.TokensAt(now)
+	status.remaining = max(int(math.Floor(tokens)), 0)
+	status.reset = l.refillTime(float64(l.burst) - tokens)
+	if !status.allowed {
+		status.retryAfter = l.refillTime(1 - tokens)
+	}
+	return status
+}
+
+// refillTime returns how long it takes for the given number of tokens to be added
+// back to a bucket.
+func (l *rateLimiter) refillTime(tokens float64) time.Duration {
+	if tokens <= 0 || l.limit <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / float64(l.limit) * float64(time.Second))
+}
+
+// evict forgets clients that have not made a request for the given duration. Their
+// buckets would have refilled by then anyway.
+func (l *rateLimiter) evict(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, c := range l.clients {
+		if time.Since(c.lastSeen) > idle {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// rateLimitKey identifies the client a request counts against.
+func (app *application) rateLimitKey(r *http.Request) string {
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() {
+		return "user:" + strconv.FormatInt(user.ID, 10)
+	}
+	return "ip:" + app.clientIP(r)
+}
+
+// rateLimit applies the limiter to next, setting the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers on every response and
+// Retry-After when the limit is exceeded. It does nothing when rate limiting is
+// disabled. It must run after authenticate, as it reads the user from the context.
+func (app *application) rateLimit(l *rateLimiter, next http.Handler) http.Handler {
+	if !app.config.limiter.enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := l.allow(app.rateLimitKey(r))
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(l.burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(status.remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(status.reset.Seconds()))))
+
+		if !status.allowed {
+			app.rateLimitExceededResponse(w, r, status.retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitRoute wraps a single route's handler with its own limiter, for routes that
+// are expensive or attractive to abuse and so need a stricter limit than the global
+// one.
+func (app *application) limitRoute(limit rate.Limit, burst int, next http.HandlerFunc) http.HandlerFunc {
+	return app.rateLimit(app.newRateLimiter(limit, burst), next).ServeHTTP
+}
+
+// runRateLimiterEviction periodically forgets idle clients from every rate limiter,
+// so that the limiters' memory use is bounded by the number of recently active
+// clients. It returns when ctx is cancelled.
+func (app *application) runRateLimiterEviction(ctx context.Context, interval time.Duration) {
+	defer app.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	app.workers.register("rate_limiter_eviction", interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, l := range app.rateLimiters {
+				l.evict(app.config.limiter.idleTimeout)
+			}
+			app.workers.beat("rate_limiter_eviction")
+		}
+	}
+}
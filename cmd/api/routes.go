@@ -2,29 +2,104 @@ package main
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/vj-2303/voting-api-go/internal/data"
+	"golang.org/x/time/rate"
 )
 
-func (app *application) routes() *httprouter.Router {
+func (app *application) routes() http.Handler {
 
 	router := httprouter.New()
 
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	// Stricter limits for the routes that hash passwords, send email or record votes,
+	// on top of the global limit applied to every request.
+	limitAccounts := func(next http.HandlerFunc) http.HandlerFunc {
+		return app.limitRoute(rate.Every(time.Minute), 3, next)
+	}
+	limitLogins := func(next http.HandlerFunc) http.HandlerFunc {
+		return app.limitRoute(rate.Every(10*time.Second), 5, next)
+	}
+	limitVotes := func(next http.HandlerFunc) http.HandlerFunc {
+		return app.limitRoute(rate.Every(time.Second), 5, next)
+	}
+
+	router.HandlerFunc(http.MethodGet, "/v1/healthz", app.livenessHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/readyz", app.readinessHandler)
+	router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", app.jwksHandler)
+	if app.config.metrics.username != "" && app.config.metrics.password != "" {
+		router.HandlerFunc(http.MethodGet, "/metrics", app.requireMetricsAuth(app.metricsHandler))
+	}
 	router.HandlerFunc(http.MethodGet, "/v1/testauth", app.requireAuthenticatedUser(app.testAuthHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users", limitAccounts(app.registerUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireAuthenticatedUser(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireAuthenticatedUser(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireAuthenticatedUser(app.exportCurrentUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireAuthenticatedUser(app.changeUserPasswordHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.requireAuthenticatedUser(app.createTOTPHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/totp", app.requireAuthenticatedUser(app.confirmTOTPHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/totp", app.requireAuthenticatedUser(app.deleteTOTPHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp/recovery-codes", app.requireAuthenticatedUser(app.regenerateRecoveryCodesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens", limitLogins(app.createAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", limitAccounts(app.createActivationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", limitAccounts(app.createPasswordResetTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/two-factor", limitLogins(app.createTwoFactorAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens", app.requireAuthenticatedUser(app.deleteAllTokensHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/current", app.requireAuthenticatedUser(app.deleteCurrentTokenHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/polls", app.requireAdminUser(app.createPollHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/polls", app.requirePermission(data.PermissionPollsCreate, app.createPollHandler))
 
+	router.HandlerFunc(http.MethodGet, "/v1/polls", app.listPollsHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/polls/:id", app.showPollHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/polls/:id/votes", app.requireAuthenticatedUser(app.castVoteHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/polls/:id", app.requirePermission(data.PermissionPollsUpdate, app.updatePollHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/polls/:id", app.requirePermission(data.PermissionPollsDelete, app.deletePollHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/polls/:id/votes", limitVotes(app.requireActivatedUser(app.castVoteHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/polls/:id/votes/me", app.requireAuthenticatedUser(app.showMyVoteHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/polls/:id/votes/me", limitVotes(app.requireActivatedUser(app.replaceMyVoteHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/polls/:id/votes/me", limitVotes(app.requireActivatedUser(app.deleteMyVoteHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/polls/:id/results", app.showPollResultsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/polls/:id/results/stream", app.streamPollResultsHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission(data.PermissionUsersManage, app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission(data.PermissionUsersManage, app.showUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id", app.requirePermission(data.PermissionUsersManage, app.deleteUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated", app.requirePermission(data.PermissionUsersManage, app.forceActivateUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/suspended", app.requirePermission(data.PermissionUsersManage, app.suspendUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/suspended", app.requirePermission(data.PermissionUsersManage, app.unsuspendUserHandler))
+
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/lockout", app.requirePermission(data.PermissionUsersManage, app.unlockUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/lockouts", app.requirePermission(data.PermissionUsersManage, app.listLockoutsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/roles", app.requirePermission(data.PermissionRolesManage, app.listRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission(data.PermissionRolesManage, app.grantRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requirePermission(data.PermissionRolesManage, app.revokeRoleHandler))
+
+	globalLimiter := app.newRateLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)
+	api := app.authenticate(app.rateLimit(globalLimiter, router))
 
-	router.HandlerFunc(http.MethodGet, "/v1/polls/:id/results", app.requireAdminUser(app.requireAuthenticatedUser(app.showPollResultsHandler)))
+	// Health probes skip authentication and rate limiting: load balancers and the
+	// kubelet share addresses with other traffic, and a 429 would make an instance
+	// flap out of rotation or be restarted. The metrics endpoint skips them too, as
+	// scrapers use basic auth rather than API tokens. These routes stay registered on
+	// the router above so that they are logged under their route and other methods
+	// get a 405.
+	mux := http.NewServeMux()
+	mux.Handle("/", api)
+	mux.HandleFunc("GET /v1/healthz", app.livenessHandler)
+	mux.HandleFunc("GET /v1/readyz", app.readinessHandler)
+	if app.config.metrics.username != "" && app.config.metrics.password != "" {
+		mux.HandleFunc("GET /metrics", app.requireMetricsAuth(app.metricsHandler))
+	}
 
-	return router
+	return app.requestID(app.traceRequest(app.logAccess(router, app.recoverPanic(mux))))
 }
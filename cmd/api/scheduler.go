@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// runPollScheduler periodically moves polls between lifecycle states based on their
+// opens_at and closes_at timestamps. It returns when ctx is cancelled.
+func (app *application) runPollScheduler(ctx context.Context, interval time.Duration) {
+	defer app.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	app.workers.register("poll_scheduler", interval)
+	app.logger.Info("starting poll scheduler", "interval", interval.String())
+
+	for {
+		opened, closed, err := app.models.Polls.TransitionStates(ctx)
+		if err != nil {
+			app.logger.Error(err.Error(), "component", "poll scheduler")
+		} else if opened > 0 || closed > 0 {
+			app.logger.Info("poll states updated", "opened", opened, "closed", closed)
+		}
+		app.workers.beat("poll_scheduler")
+
+		select {
+		case <-ctx.Done():
+			app.logger.Info("poll scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runTokenCleanup periodically deletes expired tokens and stale failed login records
+// so that the tables behind them, which gain rows with every login attempt, do not
+// grow without bound. It returns when ctx is cancelled.
+func (app *application) runTokenCleanup(ctx context.Context, interval time.Duration) {
+	defer app.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	app.workers.register("token_cleanup", interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := app.models.Tokens.DeleteExpired(ctx)
+			if err != nil {
+				app.logger.Error(err.Error(), "component", "token cleanup")
+			} else if deleted > 0 {
+				app.logger.Info("expired tokens deleted", "count", deleted)
+			}
+			deleted, err = app.models.LoginAttempts.DeleteExpired(ctx)
+			if err != nil {
+				app.logger.Error(err.Error(), "component", "token cleanup")
+			} else if deleted > 0 {
+				app.logger.Info("expired login attempts deleted", "count", deleted)
+			}
+			app.workers.beat("token_cleanup")
+		}
+	}
+}
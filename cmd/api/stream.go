@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vj-2303/voting-api-go/internal/data"
+)
+
+// streamPollResultsHandler pushes the results of a poll to the client as Server-Sent
+// Events: once on connect and again whenever a vote on the poll is recorded. The
+// visibility policy is checked before every update, since retracting a vote can
+// take away a voter's access; the stream ends with a forbidden event when it does.
+func (app *application) streamPollResultsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	poll, err := app.models.Polls.GetByID(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	allowed, err := app.canViewResults(r, poll)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		app.resultsNotVisibleResponse(w, r, poll)
+		return
+	}
+	results, err := app.models.Polls.Tally(r.Context(), poll)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The server-wide WriteTimeout would cut every stream off after a few seconds, so
+	// instead each write gets its own deadline, long enough to cover a heartbeat.
+	rc := http.NewResponseController(w)
+	writeTimeout := 2 * app.config.sse.heartbeat
+
+	updates, unsubscribe := app.resultsHub.subscribe(id)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(payload string) error {
+		err := rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprint(w, payload)
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	sendResults := func(poll *data.PollWithResults) error {
+		js, err := json.Marshal(envelope{"poll": poll})
+		if err != nil {
+			return err
+		}
+		return send(fmt.Sprintf("event: results\ndata: %s\n\n", js))
+	}
+
+	if err := sendResults(results); err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	heartbeat := time.NewTicker(app.config.sse.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-app.resultsHub.done:
+			return
+		case <-heartbeat.C:
+			if err := send(": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-updates:
+			poll, err := app.models.Polls.GetByID(r.Context(), id)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+			allowed, err := app.canViewResults(r, poll)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+			if !allowed {
+				js, err := json.Marshal(envelope{"error": resultsNotVisibleMessage(poll)})
+				if err == nil {
+					send(fmt.Sprintf("event: forbidden\ndata: %s\n\n", js))
+				}
+				return
+			}
+			results, err := app.models.Polls.Tally(r.Context(), poll)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+			if err := sendResults(results); err != nil {
+				return
+			}
+		}
+	}
+}
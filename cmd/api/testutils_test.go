@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql/driver"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/vj-2303/voting-api-go/internal/data"
+	"github.com/vj-2303/voting-api-go/internal/keyring"
+)
+
+// newTestApplication returns an application backed by a mock database. Tests set
+// the queries they expect on the returned mock; any other query fails.
+func newTestApplication(t *testing.T) (*application, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	key, err := keyring.NewHMAC("hmac", "test-secret-that-is-at-least-32-bytes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.New("hmac", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config
+	cfg.jwt.accessTTL = 15 * time.Minute
+	cfg.jwt.refreshTTL = time.Hour
+
+	return &application{
+		config:  cfg,
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models:  data.NewModels(db),
+		keys:    keys,
+		metrics: newAppMetrics(nil),
+		workers: newWorkerMonitor(),
+	}, mock
+}
+
+// capturedArg is a sqlmock argument matcher that accepts any value and remembers
+// the last one it saw.
+type capturedArg struct {
+	value driver.Value
+}
+
+func (a *capturedArg) Match(v driver.Value) bool {
+	a.value = v
+	return true
+}
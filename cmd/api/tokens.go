@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"time"
@@ -9,6 +10,60 @@ import (
 	"github.com/vj-2303/voting-api-go/internal/validator"
 )
 
+// createActivationTokenHandler re-sends an activation email, for users whose original
+// token was lost or has expired.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.background(func() {
+		data := map[string]any{
+			"activationToken": token.Plaintext,
+		}
+		err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Email    string `json:"email"`
@@ -28,30 +83,253 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	user, err := app.models.Users.GetByEmail(input.Email)
+	account, client := app.loginKeys(r, input.Email)
+
+	lockedUntil, err := app.models.LoginAttempts.LockedUntil(r.Context(), account, client)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		app.loginLockedResponse(w, r, lockedUntil)
+		return
+	}
+	// Unknown email addresses go through the same password check and failure
+	// accounting as real ones, so neither timing nor lockouts reveal which
+	// addresses have accounts.
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var match bool
+	if user != nil {
+		match, err = user.Password.Matches(input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		data.SimulatePasswordCheck(input.Password)
+	}
+	if !match {
+		err = app.recordLoginFailure(r.Context(), account, client)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+	// Users with two-factor authentication get a short-lived intermediate token
+	// instead, to be exchanged along with a code at POST /v1/tokens/two-factor.
+	// Their failed logins are only reset once that second step succeeds, so that
+	// codes cannot be guessed by logging in again after each failure.
+	enabled, err := app.models.TwoFactor.Enabled(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if enabled {
+		token, err := app.models.Tokens.New(r.Context(), user.ID, 5*time.Minute, data.ScopeTwoFactor)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"two_factor_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.LoginAttempts.Reset(r.Context(), account)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env, err := app.issueTokenPair(r.Context(), user.ID, data.NewTokenFamily())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// issueTokenPair creates a short-lived access token and a refresh token in the given
+// family, ready to be written to the client.
+func (app *application) issueTokenPair(ctx context.Context, userID int64, family string) (envelope, error) {
+	accessToken, err := app.models.Tokens.NewJWT(ctx, userID, app.config.jwt.accessTTL, data.ScopeAuthentication, family, app.keys)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := app.models.Tokens.NewRefresh(ctx, userID, app.config.jwt.refreshTTL, family)
+	if err != nil {
+		return nil, err
+	}
+	return envelope{"authentication_token": accessToken, "refresh_token": refreshToken}, nil
+}
+
+// refreshTokenHandler exchanges a refresh token for a new access and refresh token.
+// Each refresh token can only be used once; presenting one a second time means it
+// has leaked, so the whole token family is revoked.
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	token, err := app.models.Tokens.GetRefresh(r.Context(), input.RefreshToken)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.invalidAuthenticationTokenResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	user, err := app.models.Users.GetByID(r.Context(), token.UserID)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
-			app.invalidCredentialsResponse(w, r)
+			app.invalidAuthenticationTokenResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.IsDeleted() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+	err = app.models.Tokens.MarkUsed(r.Context(), token.Hash)
+	if err != nil {
+		if errors.Is(err, data.ErrTokenReused) {
+			app.logger.Warn("refresh token reuse detected, revoking token family", "user_id", token.UserID)
+			err = app.models.Tokens.DeleteFamily(r.Context(), token.Family)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			app.invalidAuthenticationTokenResponse(w, r)
 		} else {
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	match, err := user.Password.Matches(input.Password)
+	env, err := app.issueTokenPair(r.Context(), token.UserID, token.Family)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	if !match {
-		app.invalidCredentialsResponse(w, r)
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler emails a single-use password reset token. The
+// response is the same whether or not the email address belongs to an account, so
+// the endpoint cannot be used to discover registered users.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	env := envelope{"message": "if that email address has an account, an email will be sent to it containing password reset instructions"}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.background(func() {
+		data := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCurrentTokenHandler logs out by revoking the token used for the request,
+// along with the refresh tokens issued with it.
+func (app *application) deleteCurrentTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token := app.contextGetToken(r)
+
+	var err error
+	if token.Family != "" {
+		err = app.models.Tokens.DeleteFamily(r.Context(), token.Family)
+	} else {
+		err = app.models.Tokens.Delete(r.Context(), token.Hash)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-	token, err := data.GenerateToken(user.ID, 24*time.Hour, data.ScopeAuthentication, app.config.jwt.secret)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllTokensHandler logs out everywhere by revoking every access and refresh
+// token issued to the current user.
+func (app *application) deleteAllTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllSessionsForUser(r.Context(), user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out of all sessions"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
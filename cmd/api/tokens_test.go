@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/vj-2303/voting-api-go/internal/data"
+)
+
+func TestRefreshTokenHandler(t *testing.T) {
+	const plaintext = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	const family = "family-1"
+	usedAt := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name       string
+		usedAt     *time.Time
+		markedUsed int64
+		wantStatus int
+		// wantFamilyRevoked is set when reuse must revoke every token in the family.
+		wantFamilyRevoked bool
+	}{
+		{
+			name:       "first use rotates the token",
+			markedUsed: 1,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:              "reuse revokes the family",
+			usedAt:            &usedAt,
+			markedUsed:        0,
+			wantStatus:        http.StatusUnauthorized,
+			wantFamilyRevoked: true,
+		},
+		{
+			// Two concurrent refreshes both find the token unused; only one of them
+			// can mark it used.
+			name:              "concurrent reuse revokes the family",
+			markedUsed:        0,
+			wantStatus:        http.StatusUnauthorized,
+			wantFamilyRevoked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApplication(t)
+
+			mock.ExpectQuery(`SELECT hash, user_id, expiry, scope, family, used_at\s+FROM tokens`).
+				WithArgs(data.TokenHash(plaintext), data.ScopeRefresh, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"hash", "user_id", "expiry", "scope", "family", "used_at"}).
+					AddRow(data.TokenHash(plaintext), 1, time.Now().Add(time.Hour), data.ScopeRefresh, family, tt.usedAt))
+			mock.ExpectQuery(`FROM users\s+WHERE id = \$1`).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "name", "email", "password_hash", "activated", "suspended_at", "deleted_at", "version", "roles"}).
+					AddRow(1, time.Now(), "Alice", "alice@example.com", []byte("hash"), true, nil, nil, 1, "{}"))
+			mock.ExpectExec(`UPDATE tokens\s+SET used_at = NOW\(\)\s+WHERE hash = \$1 AND used_at IS NULL`).
+				WithArgs(data.TokenHash(plaintext)).
+				WillReturnResult(sqlmock.NewResult(0, tt.markedUsed))
+
+			if tt.wantFamilyRevoked {
+				mock.ExpectExec(`DELETE FROM tokens\s+WHERE family = \$1`).
+					WithArgs(family).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			} else {
+				// The new access and refresh tokens stay in the same family.
+				mock.ExpectExec(`INSERT INTO tokens`).
+					WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg(), data.ScopeAuthentication, family).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`INSERT INTO tokens`).
+					WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg(), data.ScopeRefresh, family).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			body := strings.NewReader(`{"refresh_token": "` + plaintext + `"}`)
+			rr := httptest.NewRecorder()
+			app.refreshTokenHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/tokens/refresh", body))
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if tt.wantStatus == http.StatusCreated {
+				var response struct {
+					RefreshToken struct {
+						Token string `json:"token"`
+					} `json:"refresh_token"`
+				}
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatal(err)
+				}
+				if response.RefreshToken.Token == "" || response.RefreshToken.Token == plaintext {
+					t.Errorf("refresh token not rotated: %q", response.RefreshToken.Token)
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshTokenHandlerDeletedUser(t *testing.T) {
+	const plaintext = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+	tests := []struct {
+		name string
+		rows *sqlmock.Rows
+	}{
+		{
+			name: "user no longer exists",
+			rows: sqlmock.NewRows([]string{"id"}),
+		},
+		{
+			name: "user erased their account",
+			rows: sqlmock.NewRows([]string{"id", "created_at", "name", "email", "password_hash", "activated", "suspended_at", "deleted_at", "version", "roles"}).
+				AddRow(1, time.Now(), "Deleted user", "deleted-1@invalid", []byte("hash"), false, nil, time.Now(), 2, "{}"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApplication(t)
+
+			mock.ExpectQuery(`FROM tokens`).
+				WillReturnRows(sqlmock.NewRows([]string{"hash", "user_id", "expiry", "scope", "family", "used_at"}).
+					AddRow(data.TokenHash(plaintext), 1, time.Now().Add(time.Hour), data.ScopeRefresh, "family-1", nil))
+			mock.ExpectQuery(`FROM users\s+WHERE id = \$1`).WithArgs(1).WillReturnRows(tt.rows)
+
+			body := strings.NewReader(`{"refresh_token": "` + plaintext + `"}`)
+			rr := httptest.NewRecorder()
+			app.refreshTokenHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/tokens/refresh", body))
+
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d: %s", rr.Code, http.StatusUnauthorized, rr.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/vj-2303/voting-api-go/internal/data"
+	"github.com/vj-2303/voting-api-go/internal/totp"
+	"github.com/vj-2303/voting-api-go/internal/validator"
+)
+
+// verifySecondFactor checks a code from the user's authenticator app or, failing
+// that, one of their unused recovery codes. Each code is only accepted once.
+func (app *application) verifySecondFactor(ctx context.Context, userID int64, code string) (bool, error) {
+	t, err := app.models.TwoFactor.GetTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !t.Enabled() {
+		return false, nil
+	}
+	if step, ok := totp.Validate(t.Secret, code, time.Now()); ok {
+		err = app.models.TwoFactor.UseTOTPStep(ctx, userID, step, false)
+		if err != nil {
+			if errors.Is(err, data.ErrTOTPReplay) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+	err = app.models.TwoFactor.UseRecoveryCode(ctx, userID, code)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// twoFactorMissing reports whether the user holds admin permissions but has not
+// enabled two-factor authentication while the server requires it of admins.
+func (app *application) twoFactorMissing(ctx context.Context, userID int64, permissions data.Permissions) (bool, error) {
+	if !app.config.twoFactor.requireForAdmins || !permissions.Admin() {
+		return false, nil
+	}
+	enabled, err := app.models.TwoFactor.Enabled(ctx, userID)
+	return !enabled, err
+}
+
+// createTOTPHandler starts enrolling the current user in TOTP two-factor
+// authentication. The returned secret and otpauth URI are added to an authenticator
+// app, and the enrollment only takes effect once confirmed with a code from it.
+func (app *application) createTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	enabled, err := app.models.TwoFactor.Enabled(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if enabled {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+	t, err := app.models.TwoFactor.NewTOTP(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{
+		"secret": t.Secret,
+		"uri":    totp.URI(app.config.twoFactor.issuer, user.Email, t.Secret),
+	}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTOTPHandler completes TOTP enrollment and returns the user's recovery
+// codes. This is the only time the recovery codes are shown.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user := app.contextGetUser(r)
+
+	t, err := app.models.TwoFactor.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if t.Enabled() {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+	step, ok := totp.Validate(t.Secret, input.Code, time.Now())
+	if ok {
+		err = app.models.TwoFactor.UseTOTPStep(r.Context(), user.ID, step, true)
+		if err != nil && !errors.Is(err, data.ErrTOTPReplay) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		ok = err == nil
+	}
+	if !ok {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	codes, err := app.models.TwoFactor.NewRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// regenerateRecoveryCodesHandler replaces the user's recovery codes, for example
+// once most of them have been used.
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user := app.contextGetUser(r)
+
+	ok, err := app.verifySecondFactor(r.Context(), user.ID, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+	codes, err := app.models.TwoFactor.NewRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteTOTPHandler turns off two-factor authentication. Both the password and a
+// current code are required, so a stolen session alone cannot weaken the account.
+func (app *application) deleteTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Password != "", "password", "must be provided")
+	v.Check(input.Code != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user := app.contextGetUser(r)
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+	ok, err := app.verifySecondFactor(r.Context(), user.ID, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+	err = app.models.TwoFactor.Disable(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication has been disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createTwoFactorAuthenticationTokenHandler is the second step of logging in for
+// users with two-factor authentication. It exchanges the intermediate token issued
+// by createAuthenticationTokenHandler and a code for an access and refresh token.
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"two_factor_token"`
+		Code           string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	v.Check(input.Code != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeTwoFactor, input.TokenPlaintext)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.invalidAuthenticationTokenResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Wrong codes count towards the same lockout as wrong passwords.
+	account, client := app.loginKeys(r, user.Email)
+
+	lockedUntil, err := app.models.LoginAttempts.LockedUntil(r.Context(), account, client)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		app.loginLockedResponse(w, r, lockedUntil)
+		return
+	}
+	ok, err := app.verifySecondFactor(r.Context(), user.ID, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		err = app.recordLoginFailure(r.Context(), account, client)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+	err = app.models.LoginAttempts.Reset(r.Context(), account)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeTwoFactor, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+	env, err := app.issueTokenPair(r.Context(), user.ID, data.NewTokenFamily())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/vj-2303/voting-api-go/internal/totp"
+)
+
+func TestVerifySecondFactorRejectsReplayedCode(t *testing.T) {
+	app, mock := newTestApplication(t)
+
+	secret := totp.GenerateSecret()
+	step := totp.Step(time.Now())
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enrollment := func(lastUsedStep int64) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"user_id", "secret", "confirmed_at", "last_used_step"}).
+			AddRow(1, secret, time.Now(), lastUsedStep)
+	}
+	// A code is only accepted by moving last_used_step forward, which fails once the
+	// step has been used.
+	useStep := `UPDATE users_totp\s+SET last_used_step = \$2, .*\s+WHERE user_id = \$1 AND last_used_step < \$2`
+
+	first, second := &capturedArg{}, &capturedArg{}
+
+	mock.ExpectQuery(`FROM users_totp`).WithArgs(1).WillReturnRows(enrollment(0))
+	mock.ExpectExec(useStep).WithArgs(1, first, false).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users_totp`).WithArgs(1).WillReturnRows(enrollment(step))
+	mock.ExpectExec(useStep).WithArgs(1, second, false).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"first use", true},
+		{"replay", false},
+	}
+	for _, tt := range tests {
+		ok, err := app.verifySecondFactor(context.Background(), 1, code)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ok != tt.want {
+			t.Errorf("%s: verifySecondFactor() = %t, want %t", tt.name, ok, tt.want)
+		}
+	}
+	if first.value != second.value {
+		t.Errorf("replay checked step %v, want the step first used, %v", second.value, first.value)
+	}
+}
+
+func TestVerifySecondFactorWithoutEnrollment(t *testing.T) {
+	app, mock := newTestApplication(t)
+
+	tests := []struct {
+		name string
+		rows *sqlmock.Rows
+	}{
+		{"not enrolled", sqlmock.NewRows([]string{"user_id", "secret", "confirmed_at", "last_used_step"})},
+		{"unconfirmed", sqlmock.NewRows([]string{"user_id", "secret", "confirmed_at", "last_used_step"}).
+			AddRow(1, totp.GenerateSecret(), nil, 0)},
+	}
+	for _, tt := range tests {
+		mock.ExpectQuery(`FROM users_totp`).WithArgs(1).WillReturnRows(tt.rows)
+
+		ok, err := app.verifySecondFactor(context.Background(), 1, "123456")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ok {
+			t.Errorf("%s: code accepted", tt.name)
+		}
+	}
+}
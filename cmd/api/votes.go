@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/vj-2303/voting-api-go/internal/data"
+	"github.com/vj-2303/voting-api-go/internal/validator"
+)
+
+// readBallot decodes a ballot from the request body. Single choice ballots may still
+// be submitted with the original "option" key. For ranked polls the order of "options"
+// is the voter's preference order, and score polls take a "scores" object mapping
+// every option to its score.
+func (app *application) readBallot(w http.ResponseWriter, r *http.Request, pollID, userID int64) (*data.Vote, error) {
+	var input struct {
+		Option  string         `json:"option"`
+		Options []string       `json:"options"`
+		Scores  map[string]int `json:"scores"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		return nil, err
+	}
+	if input.Options == nil && input.Option != "" {
+		input.Options = []string{input.Option}
+	}
+	vote := &data.Vote{
+		PollID:        pollID,
+		UserID:        userID,
+		ChosenOptions: input.Options,
+		Scores:        input.Scores,
+	}
+	return vote, nil
+}
+
+func (app *application) showMyVoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user := app.contextGetUser(r)
+
+	vote, err := app.models.Votes.GetForUser(r.Context(), id, user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	history, err := app.models.Votes.GetHistory(r.Context(), id, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// A voter who retracted their ballot has no current vote but still has history.
+	if vote == nil && len(history) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"vote": vote, "history": history}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) replaceMyVoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	poll, err := app.models.Polls.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !poll.AllowVoteChange {
+		app.voteChangeNotAllowedResponse(w, r)
+		return
+	}
+	if !poll.AcceptsVotes(time.Now()) {
+		app.pollNotOpenResponse(w, r, poll)
+		return
+	}
+	user := app.contextGetUser(r)
+
+	vote, err := app.readBallot(w, r, poll.ID, user.ID)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateVote(v, vote, poll); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Votes.Replace(r.Context(), vote)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.resultsHub.publish(poll.ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"vote": vote}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteMyVoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	poll, err := app.models.Polls.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !poll.AllowVoteChange {
+		app.voteChangeNotAllowedResponse(w, r)
+		return
+	}
+	if !poll.AcceptsVotes(time.Now()) {
+		app.pollNotOpenResponse(w, r, poll)
+		return
+	}
+	user := app.contextGetUser(r)
+
+	err = app.models.Votes.Delete(r.Context(), poll.ID, user.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.resultsHub.publish(poll.ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "vote successfully retracted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
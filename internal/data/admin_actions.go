@@ -0,0 +1,90 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	AdminActionActivate   = "activate"
+	AdminActionSuspend    = "suspend"
+	AdminActionUnsuspend  = "unsuspend"
+	AdminActionDelete     = "delete"
+	AdminActionGrantRole  = "grant_role"
+	AdminActionRevokeRole = "revoke_role"
+	AdminActionUnlock     = "unlock"
+)
+
+// AdminAction is an audit record of an administrator acting on a user account.
+// AdminID is nil once the acting administrator's own account has been deleted.
+type AdminAction struct {
+	ID           int64          `json:"id"`
+	AdminID      *int64         `json:"admin_id"`
+	TargetUserID int64          `json:"target_user_id"`
+	Action       string         `json:"action"`
+	Details      map[string]any `json:"details,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+type AdminActionModel struct {
+	DB *sql.DB
+}
+
+// recordAdminAction appends an entry to admin_actions within the caller's
+// transaction, so that an administrator's change is never committed unaudited.
+func recordAdminAction(ctx context.Context, tx *sql.Tx, action *AdminAction) error {
+	query := `
+		INSERT INTO admin_actions (admin_id, target_user_id, action, details)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+			 `
+	details, err := json.Marshal(action.Details)
+	if err != nil {
+		return err
+	}
+	if action.Details == nil {
+		details = []byte("{}")
+	}
+	args := []any{action.AdminID, action.TargetUserID, action.Action, details}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&action.ID, &action.CreatedAt)
+}
+
+// GetAllForUser returns every action taken on the given user, newest first.
+func (m AdminActionModel) GetAllForUser(ctx context.Context, userID int64) ([]*AdminAction, error) {
+	query := `
+		SELECT id, admin_id, target_user_id, action, details, created_at
+		FROM admin_actions
+		WHERE target_user_id = $1
+		ORDER BY id DESC
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []*AdminAction{}
+
+	for rows.Next() {
+		var action AdminAction
+		var details []byte
+		err := rows.Scan(&action.ID, &action.AdminID, &action.TargetUserID, &action.Action, &details, &action.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(details, &action.Details); err != nil {
+			return nil, err
+		}
+		actions = append(actions, &action)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
@@ -0,0 +1,56 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SchemaVersion is the state of the migrations applied to the database, as recorded
+// by the migrate tool in the schema_migrations table.
+type SchemaVersion struct {
+	Version int64 `json:"version"`
+	// Dirty is set when a migration failed part way through and the schema needs
+	// fixing by hand.
+	Dirty bool `json:"dirty"`
+}
+
+type HealthModel struct {
+	DB *sql.DB
+}
+
+// Ping checks that a connection to the database can be used.
+func (m HealthModel) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.DB.PingContext(ctx)
+}
+
+// SchemaVersion returns the latest migration applied to the database. It returns
+// ErrRecordNotFound if no migrations have been run.
+func (m HealthModel) SchemaVersion(ctx context.Context) (*SchemaVersion, error) {
+	query := `
+		SELECT version, dirty
+		FROM schema_migrations
+		LIMIT 1
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var version SchemaVersion
+	err := m.DB.QueryRowContext(ctx, query).Scan(&version.Version, &version.Dirty)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &version, nil
+}
+
+// Stats returns the connection pool statistics.
+func (m HealthModel) Stats() sql.DBStats {
+	return m.DB.Stats()
+}
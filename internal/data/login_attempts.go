@@ -0,0 +1,187 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LoginFailureWindow is how long a failed login is remembered. A failure after a
+// quiet period this long starts counting from one again.
+const LoginFailureWindow = 24 * time.Hour
+
+// LoginAttempts tracks failed logins for one account or client.
+type LoginAttempts struct {
+	Key          string     `json:"key"`
+	UserID       *int64     `json:"user_id,omitempty"`
+	Failures     int        `json:"failures"`
+	LastFailedAt time.Time  `json:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+}
+
+// LoginEmailKey and LoginIPKey build the keys failed logins are tracked under.
+func LoginEmailKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func LoginIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// LockedUntil returns the latest time until which any of the keys is locked out,
+// or the zero time if none of them is locked.
+func (m LoginAttemptModel) LockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	query := `
+		SELECT COALESCE(MAX(locked_until), 'epoch')
+		FROM login_attempts
+		WHERE key = ANY($1) AND locked_until > NOW()
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var lockedUntil time.Time
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(keys)).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !lockedUntil.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return lockedUntil, nil
+}
+
+// RecordFailure counts a failed login against the key and returns the number of
+// failures within LoginFailureWindow, including this one.
+func (m LoginAttemptModel) RecordFailure(ctx context.Context, key string) (int, error) {
+	query := `
+		INSERT INTO login_attempts (key, failures, last_failed_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE
+				WHEN login_attempts.last_failed_at < NOW() - $2 * interval '1 second' THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failed_at = NOW()
+		RETURNING failures
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var failures int
+	err := m.DB.QueryRowContext(ctx, query, key, LoginFailureWindow.Seconds()).Scan(&failures)
+	return failures, err
+}
+
+func (m LoginAttemptModel) Lock(ctx context.Context, key string, until time.Time) error {
+	query := `
+		UPDATE login_attempts
+		SET locked_until = $2
+		WHERE key = $1
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key, until)
+	return err
+}
+
+// Reset forgets the failed logins of a key, unlocking it.
+func (m LoginAttemptModel) Reset(ctx context.Context, key string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE key = $1
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
+
+// Unlock clears the failed logins recorded against key on an administrator's
+// behalf and records the action in the same transaction.
+func (m LoginAttemptModel) Unlock(ctx context.Context, key string, action *AdminAction) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE key = $1
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, key)
+	if err != nil {
+		return err
+	}
+	err = recordAdminAction(ctx, tx, action)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetLocked returns every key that is currently locked out, along with the id of
+// the matching user for account keys.
+func (m LoginAttemptModel) GetLocked(ctx context.Context) ([]*LoginAttempts, error) {
+	query := `
+		SELECT login_attempts.key, users.id, login_attempts.failures,
+			login_attempts.last_failed_at, login_attempts.locked_until
+		FROM login_attempts
+		LEFT JOIN users ON login_attempts.key = 'email:' || lower(users.email)
+		WHERE login_attempts.locked_until > NOW()
+		ORDER BY login_attempts.locked_until DESC
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locked := []*LoginAttempts{}
+
+	for rows.Next() {
+		var attempts LoginAttempts
+		err := rows.Scan(&attempts.Key, &attempts.UserID, &attempts.Failures, &attempts.LastFailedAt, &attempts.LockedUntil)
+		if err != nil {
+			return nil, err
+		}
+		locked = append(locked, &attempts)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return locked, nil
+}
+
+// DeleteExpired removes records whose failures are older than LoginFailureWindow
+// and which are no longer locked.
+func (m LoginAttemptModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM login_attempts
+		WHERE last_failed_at < NOW() - $1 * interval '1 second'
+		AND (locked_until IS NULL OR locked_until < NOW())
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, LoginFailureWindow.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
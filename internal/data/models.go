@@ -3,9 +3,16 @@ package data
 import "database/sql"
 
 type Models struct {
-	Users UserModel
-	Polls PollsModel
-	Votes VotesModel
+	Users         UserModel
+	Polls         PollsModel
+	Votes         VotesModel
+	Tokens        TokenModel
+	Roles         RoleModel
+	Permissions   PermissionModel
+	AdminActions  AdminActionModel
+	TwoFactor     TwoFactorModel
+	LoginAttempts LoginAttemptModel
+	Health        HealthModel
 }
 
 func NewModels(db *sql.DB) Models {
@@ -19,5 +26,26 @@ func NewModels(db *sql.DB) Models {
 		Votes: VotesModel{
 			DB: db,
 		},
+		Tokens: TokenModel{
+			DB: db,
+		},
+		Roles: RoleModel{
+			DB: db,
+		},
+		Permissions: PermissionModel{
+			DB: db,
+		},
+		AdminActions: AdminActionModel{
+			DB: db,
+		},
+		TwoFactor: TwoFactorModel{
+			DB: db,
+		},
+		LoginAttempts: LoginAttemptModel{
+			DB: db,
+		},
+		Health: HealthModel{
+			DB: db,
+		},
 	}
 }
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+)
+
+const (
+	PermissionPollsCreate      = "polls:create"
+	PermissionPollsUpdate      = "polls:update"
+	PermissionPollsDelete      = "polls:delete"
+	PermissionPollsResultsRead = "polls:results:read"
+	PermissionUsersManage      = "users:manage"
+	PermissionRolesManage      = "roles:manage"
+)
+
+// Permissions holds the permission codes granted to a user through their roles.
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+// Admin reports whether the permissions include managing users or roles, which
+// together amount to full control over the API.
+func (p Permissions) Admin() bool {
+	return p.Include(PermissionUsersManage) || p.Include(PermissionRolesManage)
+}
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	query := `
+		SELECT DISTINCT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1
+		ORDER BY permissions.code
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
@@ -4,25 +4,125 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/lib/pq"
+	"github.com/vj-2303/voting-api-go/internal/tally"
 	"github.com/vj-2303/voting-api-go/internal/validator"
 )
 
+var (
+	ErrEditConflict = errors.New("edit conflict")
+)
+
+const (
+	PollStatusDraft     = "draft"
+	PollStatusScheduled = "scheduled"
+	PollStatusOpen      = "open"
+	PollStatusClosed    = "closed"
+	PollStatusArchived  = "archived"
+)
+
+var PollStatuses = []string{
+	PollStatusDraft,
+	PollStatusScheduled,
+	PollStatusOpen,
+	PollStatusClosed,
+	PollStatusArchived,
+}
+
+const (
+	VotingModeSingle   = "single"
+	VotingModeMultiple = "multiple"
+	VotingModeRanked   = "ranked"
+	VotingModeScore    = "score"
+)
+
+var VotingModes = []string{
+	VotingModeSingle,
+	VotingModeMultiple,
+	VotingModeRanked,
+	VotingModeScore,
+}
+
+const (
+	ResultsVisibilityPublic     = "public"
+	ResultsVisibilityAfterVote  = "after_vote"
+	ResultsVisibilityAfterClose = "after_close"
+	ResultsVisibilityPrivate    = "private"
+)
+
+var ResultsVisibilities = []string{
+	ResultsVisibilityPublic,
+	ResultsVisibilityAfterVote,
+	ResultsVisibilityAfterClose,
+	ResultsVisibilityPrivate,
+}
+
 type Poll struct {
-	ID          int64     `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	Title       string    `json:"title"`
-	Description string    `json:"description,omitempty"`
-	Options     []string  `json:"options"`
-	CreatedBy   int64     `json:"created_by"`
-	Version     int       `json:"version"`
+	ID                int64      `json:"id"`
+	CreatedAt         time.Time  `json:"created_at"`
+	Title             string     `json:"title"`
+	Description       string     `json:"description,omitempty"`
+	Options           []string   `json:"options"`
+	VotingMode        string     `json:"voting_mode"`
+	MinChoices        int        `json:"min_choices"`
+	MaxChoices        int        `json:"max_choices"`
+	ScoreMin          *int       `json:"score_min,omitempty"`
+	ScoreMax          *int       `json:"score_max,omitempty"`
+	AllowVoteChange   bool       `json:"allow_vote_change"`
+	ResultsVisibility string     `json:"results_visibility"`
+	Status            string     `json:"status"`
+	OpensAt           *time.Time `json:"opens_at,omitempty"`
+	ClosesAt          *time.Time `json:"closes_at,omitempty"`
+	CreatedBy         int64      `json:"created_by"`
+	Version           int        `json:"version"`
+}
+
+// InitialStatus picks the lifecycle state for a newly created poll that did not
+// ask for one explicitly: scheduled if it opens in the future, open otherwise.
+func (p *Poll) InitialStatus(now time.Time) string {
+	if p.OpensAt != nil && p.OpensAt.After(now) {
+		return PollStatusScheduled
+	}
+	return PollStatusOpen
+}
+
+// IsClosed reports whether voting on the poll has finished at time t.
+func (p *Poll) IsClosed(t time.Time) bool {
+	if p.Status == PollStatusClosed || p.Status == PollStatusArchived {
+		return true
+	}
+	return p.Status == PollStatusOpen && p.ClosesAt != nil && !t.Before(*p.ClosesAt)
+}
+
+// AcceptsVotes reports whether a vote cast at time t should be recorded. The
+// opens_at/closes_at window is checked as well as the status so that votes are
+// refused on time even if the scheduler has not yet caught up.
+func (p *Poll) AcceptsVotes(t time.Time) bool {
+	if p.Status != PollStatusOpen {
+		return false
+	}
+	if p.OpensAt != nil && t.Before(*p.OpensAt) {
+		return false
+	}
+	if p.ClosesAt != nil && !t.Before(*p.ClosesAt) {
+		return false
+	}
+	return true
 }
 
+// PollWithResults carries the outcome of a poll. Results holds per-option counts for
+// single and multiple choice polls; ranked polls report Runoff and Schulze instead,
+// and score polls report per-option Scores.
 type PollWithResults struct {
 	*Poll
-	Results map[string]int `json:"results"`
+	TotalBallots int                           `json:"total_ballots"`
+	Results      map[string]int                `json:"results,omitempty"`
+	Runoff       *tally.RunoffResult           `json:"runoff,omitempty"`
+	Schulze      *tally.SchulzeResult          `json:"schulze,omitempty"`
+	Scores       map[string]tally.ScoreSummary `json:"scores,omitempty"`
 }
 
 func ValidatePoll(v *validator.Validator, poll *Poll) {
@@ -33,38 +133,88 @@ func ValidatePoll(v *validator.Validator, poll *Poll) {
 	v.Check(len(poll.Options) >= 2, "options", "must contain at least 2 options")
 	v.Check(len(poll.Options) <= 20, "options", "must be less than 20 options")
 	v.Check(validator.Unique(poll.Options), "options", "must not contain duplicate values")
+
+	v.Check(validator.In(poll.VotingMode, VotingModes...), "voting_mode", "must be one of single, multiple, ranked or score")
+	switch poll.VotingMode {
+	case VotingModeSingle:
+		v.Check(poll.MinChoices == 1 && poll.MaxChoices == 1, "max_choices", "must be 1 for single choice polls")
+	case VotingModeMultiple, VotingModeRanked:
+		v.Check(poll.MinChoices >= 1, "min_choices", "must be at least 1")
+		v.Check(poll.MaxChoices >= poll.MinChoices, "max_choices", "must not be less than min_choices")
+		v.Check(poll.MaxChoices <= len(poll.Options), "max_choices", "must not be more than the number of options")
+	case VotingModeScore:
+		v.Check(poll.MinChoices == len(poll.Options) && poll.MaxChoices == len(poll.Options), "max_choices", "must equal the number of options for score polls")
+		v.Check(poll.ScoreMin != nil, "score_min", "must be provided for score polls")
+		v.Check(poll.ScoreMax != nil, "score_max", "must be provided for score polls")
+		if poll.ScoreMin != nil && poll.ScoreMax != nil {
+			v.Check(*poll.ScoreMin >= -100, "score_min", "must not be less than -100")
+			v.Check(*poll.ScoreMax <= 100, "score_max", "must not be more than 100")
+			v.Check(*poll.ScoreMax > *poll.ScoreMin, "score_max", "must be greater than score_min")
+		}
+	}
+	if poll.VotingMode != VotingModeScore {
+		v.Check(poll.ScoreMin == nil && poll.ScoreMax == nil, "score_min", "must only be provided for score polls")
+	}
+
+	v.Check(validator.In(poll.ResultsVisibility, ResultsVisibilities...), "results_visibility", "must be one of public, after_vote, after_close or private")
+
+	v.Check(validator.In(poll.Status, PollStatuses...), "status", "must be one of draft, scheduled, open, closed or archived")
+	if poll.Status == PollStatusScheduled {
+		v.Check(poll.OpensAt != nil, "opens_at", "must be provided for a scheduled poll")
+	}
+	if poll.OpensAt != nil && poll.ClosesAt != nil {
+		v.Check(poll.ClosesAt.After(*poll.OpensAt), "closes_at", "must be after opens_at")
+	}
 }
 
 type PollsModel struct {
 	DB *sql.DB
 }
 
-func (m PollsModel) Insert(poll *Poll) error {
+func (m PollsModel) Insert(ctx context.Context, poll *Poll) error {
 	query := `
-		INSERT INTO polls(title, description, options, created_by)
-		VALUES ($1,$2,$3,$4)
+		INSERT INTO polls(title, description, options, voting_mode, min_choices, max_choices,
+			score_min, score_max, allow_vote_change, results_visibility, status, opens_at, closes_at,
+			created_by)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
 		RETURNING id, created_at, version
 			 `
-	args := []any{poll.Title, poll.Description, pq.Array(poll.Options), poll.CreatedBy}
+	args := []any{
+		poll.Title,
+		poll.Description,
+		pq.Array(poll.Options),
+		poll.VotingMode,
+		poll.MinChoices,
+		poll.MaxChoices,
+		poll.ScoreMin,
+		poll.ScoreMax,
+		poll.AllowVoteChange,
+		poll.ResultsVisibility,
+		poll.Status,
+		poll.OpensAt,
+		poll.ClosesAt,
+		poll.CreatedBy,
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&poll.ID, &poll.CreatedAt, &poll.Version)
 }
 
-func (m PollsModel) GetByID(id int64) (*Poll, error) {
+func (m PollsModel) GetByID(ctx context.Context, id int64) (*Poll, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-		SELECT id, created_at, title, description,options,created_by, version
+		SELECT id, created_at, title, description, options, voting_mode, min_choices, max_choices,
+			score_min, score_max, allow_vote_change, results_visibility, status, opens_at, closes_at, created_by, version
 		FROM polls
 		WHERE id = $1
 			 `
 	var poll Poll
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
@@ -73,6 +223,16 @@ func (m PollsModel) GetByID(id int64) (*Poll, error) {
 		&poll.Title,
 		&poll.Description,
 		pq.Array(&poll.Options), // Use pq.Array to scan the text array
+		&poll.VotingMode,
+		&poll.MinChoices,
+		&poll.MaxChoices,
+		&poll.ScoreMin,
+		&poll.ScoreMax,
+		&poll.AllowVoteChange,
+		&poll.ResultsVisibility,
+		&poll.Status,
+		&poll.OpensAt,
+		&poll.ClosesAt,
 		&poll.CreatedBy,
 		&poll.Version,
 	)
@@ -85,21 +245,250 @@ func (m PollsModel) GetByID(id int64) (*Poll, error) {
 	return &poll, nil
 }
 
-func (m PollsModel) GetWithResults(id int64) (*PollWithResults, error) {
+// GetAllForCreator returns every poll created by the given user, oldest first.
+func (m PollsModel) GetAllForCreator(ctx context.Context, userID int64) ([]*Poll, error) {
+	query := `
+		SELECT id, created_at, title, description, options, voting_mode, min_choices, max_choices,
+			score_min, score_max, allow_vote_change, results_visibility, status, opens_at, closes_at, created_by, version
+		FROM polls
+		WHERE created_by = $1
+		ORDER BY id
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
 
-	poll, err := m.GetByID(id)
+	rows, err := m.DB.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	polls := []*Poll{}
+
+	for rows.Next() {
+		var poll Poll
+		err := rows.Scan(
+			&poll.ID,
+			&poll.CreatedAt,
+			&poll.Title,
+			&poll.Description,
+			pq.Array(&poll.Options),
+			&poll.VotingMode,
+			&poll.MinChoices,
+			&poll.MaxChoices,
+			&poll.ScoreMin,
+			&poll.ScoreMax,
+			&poll.AllowVoteChange,
+			&poll.ResultsVisibility,
+			&poll.Status,
+			&poll.OpensAt,
+			&poll.ClosesAt,
+			&poll.CreatedBy,
+			&poll.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		polls = append(polls, &poll)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return polls, nil
+}
+
+// Update applies the changes in poll using optimistic locking on the version column.
+// The options and ballot rules may only change while the poll has no votes; otherwise
+// existing ballots could refer to options that no longer exist or break the rules.
+func (m PollsModel) Update(ctx context.Context, poll *Poll) error {
 	query := `
-		SELECT chosen_option, count(*)
-		FROM votes
+		UPDATE polls
+		SET title = $1, description = $2, options = $3, voting_mode = $4, min_choices = $5,
+			max_choices = $6, score_min = $7, score_max = $8, allow_vote_change = $9,
+			results_visibility = $10, status = $11, opens_at = $12, closes_at = $13,
+			version = version + 1
+		WHERE id = $14 AND version = $15
+		AND ((options = $3 AND voting_mode = $4 AND min_choices = $5 AND max_choices = $6
+			AND score_min IS NOT DISTINCT FROM $7 AND score_max IS NOT DISTINCT FROM $8)
+			OR NOT EXISTS (SELECT 1 FROM votes WHERE votes.poll_id = polls.id))
+		RETURNING version
+			 `
+	args := []any{
+		poll.Title,
+		poll.Description,
+		pq.Array(poll.Options),
+		poll.VotingMode,
+		poll.MinChoices,
+		poll.MaxChoices,
+		poll.ScoreMin,
+		poll.ScoreMax,
+		poll.AllowVoteChange,
+		poll.ResultsVisibility,
+		poll.Status,
+		poll.OpensAt,
+		poll.ClosesAt,
+		poll.ID,
+		poll.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&poll.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return err
+	}
+	return nil
+}
+
+func (m PollsModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM polls
+		WHERE id = $1
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m PollsModel) GetAll(ctx context.Context, title string, createdBy int64, status string, createdFrom, createdTo *time.Time, filters Filters) ([]*Poll, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, description, options, voting_mode, min_choices,
+			max_choices, score_min, score_max, allow_vote_change, results_visibility, status, opens_at,
+			closes_at, created_by, version
+		FROM polls
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (created_by = $2 OR $2 = 0)
+		AND (status = $3 OR $3 = '')
+		AND (created_at >= $4::timestamptz OR $4::timestamptz IS NULL)
+		AND (created_at < $5::timestamptz OR $5::timestamptz IS NULL)
+		ORDER BY %s %s, id ASC
+		LIMIT $6 OFFSET $7
+			 `, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	args := []any{title, createdBy, status, createdFrom, createdTo, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	polls := []*Poll{}
+
+	for rows.Next() {
+		var poll Poll
+		err := rows.Scan(
+			&totalRecords,
+			&poll.ID,
+			&poll.CreatedAt,
+			&poll.Title,
+			&poll.Description,
+			pq.Array(&poll.Options),
+			&poll.VotingMode,
+			&poll.MinChoices,
+			&poll.MaxChoices,
+			&poll.ScoreMin,
+			&poll.ScoreMax,
+			&poll.AllowVoteChange,
+			&poll.ResultsVisibility,
+			&poll.Status,
+			&poll.OpensAt,
+			&poll.ClosesAt,
+			&poll.CreatedBy,
+			&poll.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		polls = append(polls, &poll)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return polls, metadata, nil
+}
+
+func (m PollsModel) GetWithResults(ctx context.Context, id int64) (*PollWithResults, error) {
+
+	poll, err := m.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return m.Tally(ctx, poll)
+}
+
+// Tally counts the ballots cast on a poll already loaded with GetByID, so that
+// callers can check who may see the results before doing the work.
+func (m PollsModel) Tally(ctx context.Context, poll *Poll) (*PollWithResults, error) {
+	id := poll.ID
+
+	if poll.VotingMode == VotingModeRanked {
+		ballots, err := m.getBallots(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		runoff := tally.InstantRunoff(poll.Options, ballots)
+		schulze := tally.Schulze(poll.Options, ballots)
+		return &PollWithResults{
+			Poll:         poll,
+			TotalBallots: len(ballots),
+			Runoff:       &runoff,
+			Schulze:      &schulze,
+		}, nil
+	}
+	if poll.VotingMode == VotingModeScore {
+		ballots, err := m.getScoreBallots(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return &PollWithResults{
+			Poll:         poll,
+			TotalBallots: len(ballots),
+			Scores:       tally.Scores(poll.Options, *poll.ScoreMin, *poll.ScoreMax, ballots),
+		}, nil
+	}
+	// Each ballot may approve several options, so count individual selections
+	// rather than rows and report the number of ballots separately.
+	query := `
+		SELECT option, count(*)
+		FROM votes, unnest(votes.chosen_options) AS option
 		WHERE poll_id = $1
-		GROUP BY chosen_option
+		GROUP BY option
 			 `
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
+	var totalBallots int
+	err := m.DB.QueryRowContext(ctx, `SELECT count(*) FROM votes WHERE poll_id = $1`, id).Scan(&totalBallots)
+	if err != nil {
+		return nil, err
+	}
+
 	rows, err := m.DB.QueryContext(ctx, query, id)
 	if err != nil {
 		return nil, err
@@ -120,8 +509,123 @@ func (m PollsModel) GetWithResults(id int64) (*PollWithResults, error) {
 		return nil, err
 	}
 	PollWithResults := &PollWithResults{
-		Poll:    poll,
-		Results: results,
+		Poll:         poll,
+		TotalBallots: totalBallots,
+		Results:      results,
 	}
 	return PollWithResults, nil
 }
+
+// getBallots returns the chosen options of every ballot cast on a poll, preserving
+// the order in which they were chosen so that rankings survive.
+func (m PollsModel) getBallots(ctx context.Context, pollID int64) ([][]string, error) {
+	query := `
+		SELECT chosen_options
+		FROM votes
+		WHERE poll_id = $1
+		ORDER BY id
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ballots := [][]string{}
+
+	for rows.Next() {
+		var ballot []string
+		if err := rows.Scan(pq.Array(&ballot)); err != nil {
+			return nil, err
+		}
+		ballots = append(ballots, ballot)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ballots, nil
+}
+
+// getScoreBallots returns the option scores of every ballot cast on a score poll.
+func (m PollsModel) getScoreBallots(ctx context.Context, pollID int64) ([]map[string]int, error) {
+	query := `
+		SELECT chosen_options, scores
+		FROM votes
+		WHERE poll_id = $1
+		ORDER BY id
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ballots := []map[string]int{}
+
+	for rows.Next() {
+		var options []string
+		var scores []int64
+		if err := rows.Scan(pq.Array(&options), pq.Array(&scores)); err != nil {
+			return nil, err
+		}
+		ballot := make(map[string]int, len(options))
+		for i := range min(len(options), len(scores)) {
+			ballot[options[i]] = int(scores[i])
+		}
+		ballots = append(ballots, ballot)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ballots, nil
+}
+
+// TransitionStates moves scheduled polls whose opens_at has passed to open, and open
+// or scheduled polls whose closes_at has passed to closed. It returns the number of
+// polls opened and closed.
+func (m PollsModel) TransitionStates(ctx context.Context) (opened int64, closed int64, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE polls
+		SET status = 'open', version = version + 1
+		WHERE status = 'scheduled' AND opens_at <= NOW()
+		AND (closes_at IS NULL OR closes_at > NOW())
+			 `
+	result, err := tx.ExecContext(ctx, query)
+	if err != nil {
+		return 0, 0, err
+	}
+	opened, err = result.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	query = `
+		UPDATE polls
+		SET status = 'closed', version = version + 1
+		WHERE status IN ('scheduled', 'open') AND closes_at <= NOW()
+			 `
+	result, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		return 0, 0, err
+	}
+	closed, err = result.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+	return opened, closed, tx.Commit()
+}
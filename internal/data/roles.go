@@ -0,0 +1,134 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Role struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+func (m RoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	query := `
+		SELECT roles.id, roles.name, roles.description,
+			ARRAY(
+				SELECT permissions.code
+				FROM permissions
+				INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+				WHERE roles_permissions.role_id = roles.id
+				ORDER BY permissions.code
+			)
+		FROM roles
+		ORDER BY roles.id
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(&role.ID, &role.Name, &role.Description, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// Grant gives the named role to a user, recording which user granted it. Granting a
+// role the user already has is not an error. ErrRecordNotFound is returned if the
+// role does not exist. The grant and the administrator's action are recorded in
+// one transaction.
+func (m RoleModel) Grant(ctx context.Context, userID int64, roleName string, grantedBy int64, action *AdminAction) error {
+	query := `
+		INSERT INTO users_roles (user_id, role_id, granted_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roleID int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM roles WHERE name = $1`, roleName).Scan(&roleID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, userID, roleID, grantedBy)
+	if err != nil {
+		return err
+	}
+	err = recordAdminAction(ctx, tx, action)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Revoke removes the named role from a user, returning ErrRecordNotFound if the user
+// did not have it. The administrator's action is recorded in the same transaction.
+func (m RoleModel) Revoke(ctx context.Context, userID int64, roleName string, action *AdminAction) error {
+	query := `
+		DELETE FROM users_roles
+		USING roles
+		WHERE users_roles.role_id = roles.id
+		AND users_roles.user_id = $1 AND roles.name = $2
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, userID, roleName)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	err = recordAdminAction(ctx, tx, action)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,14 +1,28 @@
 package data
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
 	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/vj-2303/voting-api-go/internal/validator"
 )
 
 const (
+	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeTwoFactor      = "two-factor"
+)
+
+var (
+	ErrTokenReused = errors.New("refresh token reused")
 )
 
 type Token struct {
@@ -17,26 +31,54 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	// Family links an access token to the refresh tokens it was issued alongside.
+	// Every rotation of a refresh token stays in the same family.
+	Family string     `json:"-"`
+	UsedAt *time.Time `json:"-"`
 }
 
-func GenerateToken(userID int64, ttl time.Duration, scope string, secret string) (*Token, error) {
+// NewTokenFamily returns a fresh identifier for a login session's token family.
+func NewTokenFamily() string {
+	return rand.Text()
+}
+
+// TokenHash returns the SHA-256 hash under which a token is stored. For opaque tokens
+// the plaintext is hashed; for JWTs it is the token's jti claim.
+func TokenHash(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// TokenSigner signs JWT claims; it is satisfied by *keyring.Keyring.
+type TokenSigner interface {
+	Sign(claims jwt.Claims) (string, error)
+}
+
+// GenerateToken signs a JWT for the user. Each token carries a random jti whose hash
+// is set in Token.Hash, so that the token can be persisted and later revoked.
+func GenerateToken(userID int64, ttl time.Duration, scope, family string, signer TokenSigner) (*Token, error) {
+	jti := rand.Text()
 
 	token := &Token{
+		Hash:   TokenHash(jti),
 		UserID: userID,
 		Expiry: time.Now().Add(ttl),
 		Scope:  scope,
+		Family: family,
 	}
 
 	claims := jwt.MapClaims{
 		"sub":   strconv.FormatInt(userID, 10),
 		"scope": token.Scope,
+		"jti":   jti,
 		"exp":   jwt.NewNumericDate(token.Expiry),
 		"iat":   jwt.NewNumericDate(time.Now()),
 	}
+	if family != "" {
+		claims["fam"] = family
+	}
 
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	signedString, err := jwtToken.SignedString([]byte(secret))
+	signedString, err := signer.Sign(claims)
 	if err != nil {
 		return nil, err
 	}
@@ -44,3 +86,193 @@ func GenerateToken(userID int64, ttl time.Duration, scope string, secret string)
 
 	return token, nil
 }
+
+// generateOpaqueToken creates a random single-use token. Only the SHA-256 hash of
+// the plaintext is ever stored, so a leaked tokens table cannot be replayed.
+func generateOpaqueToken(userID int64, ttl time.Duration, scope string) *Token {
+	token := &Token{
+		Plaintext: rand.Text(),
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+	}
+	token.Hash = TokenHash(token.Plaintext)
+
+	return token
+}
+
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
+
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// New generates a single-use token for the user and stores its hash.
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := generateOpaqueToken(userID, ttl, scope)
+
+	err := m.Insert(ctx, token)
+	return token, err
+}
+
+// NewJWT signs a JWT for the user and records its jti so that it can be revoked.
+func (m TokenModel) NewJWT(ctx context.Context, userID int64, ttl time.Duration, scope, family string, signer TokenSigner) (*Token, error) {
+	token, err := GenerateToken(userID, ttl, scope, family, signer)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope, family)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+			 `
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE scope = $1 AND user_id = $2
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	return err
+}
+
+// NewRefresh generates a refresh token belonging to the given family.
+func (m TokenModel) NewRefresh(ctx context.Context, userID int64, ttl time.Duration, family string) (*Token, error) {
+	token := generateOpaqueToken(userID, ttl, ScopeRefresh)
+	token.Family = family
+
+	err := m.Insert(ctx, token)
+	return token, err
+}
+
+// GetRefresh looks up an unexpired refresh token, including ones that have already
+// been used, so that reuse can be detected.
+func (m TokenModel) GetRefresh(ctx context.Context, tokenPlaintext string) (*Token, error) {
+	query := `
+		SELECT hash, user_id, expiry, scope, family, used_at
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > $3
+			 `
+	args := []any{TokenHash(tokenPlaintext), ScopeRefresh, time.Now()}
+
+	var token Token
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&token.Family,
+		&token.UsedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed records that a refresh token has been exchanged. It returns ErrTokenReused
+// if the token had already been used, including by a concurrent request.
+func (m TokenModel) MarkUsed(ctx context.Context, hash []byte) error {
+	query := `
+		UPDATE tokens
+		SET used_at = NOW()
+		WHERE hash = $1 AND used_at IS NULL
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hash)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTokenReused
+	}
+	return nil
+}
+
+// DeleteFamily revokes every access and refresh token in a family.
+func (m TokenModel) DeleteFamily(ctx context.Context, family string) error {
+	query := `
+		DELETE FROM tokens
+		WHERE family = $1
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, family)
+	return err
+}
+
+// DeleteAllSessionsForUser revokes every access and refresh token issued to the user,
+// logging them out everywhere.
+func (m TokenModel) DeleteAllSessionsForUser(ctx context.Context, userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE scope IN ($1, $2) AND user_id = $3
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, ScopeAuthentication, ScopeRefresh, userID)
+	return err
+}
+
+// Delete revokes a single token by its hash.
+func (m TokenModel) Delete(ctx context.Context, hash []byte) error {
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, hash)
+	return err
+}
+
+// DeleteExpired removes tokens that can no longer be used and returns how many
+// were deleted.
+func (m TokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expiry <= NOW()
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
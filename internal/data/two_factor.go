@@ -0,0 +1,194 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/vj-2303/voting-api-go/internal/totp"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrTOTPReplay = errors.New("totp code already used")
+)
+
+// TOTP is a user's authenticator app enrollment. It only protects logins once
+// ConfirmedAt is set, which happens after the user proves they can generate codes.
+type TOTP struct {
+	UserID       int64
+	Secret       string
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+}
+
+func (t *TOTP) Enabled() bool {
+	return t.ConfirmedAt != nil
+}
+
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// NewTOTP starts a new enrollment for the user with a fresh secret, replacing any
+// unconfirmed one.
+func (m TwoFactorModel) NewTOTP(ctx context.Context, userID int64) (*TOTP, error) {
+	query := `
+		INSERT INTO users_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, confirmed_at = NULL, last_used_step = 0
+			 `
+	t := &TOTP{UserID: userID, Secret: totp.GenerateSecret()}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, t.UserID, t.Secret)
+	return t, err
+}
+
+func (m TwoFactorModel) GetTOTP(ctx context.Context, userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, secret, confirmed_at, last_used_step
+		FROM users_totp
+		WHERE user_id = $1
+			 `
+	var t TOTP
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&t.UserID, &t.Secret, &t.ConfirmedAt, &t.LastUsedStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Enabled reports whether the user has a confirmed TOTP enrollment.
+func (m TwoFactorModel) Enabled(ctx context.Context, userID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (SELECT 1 FROM users_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL)
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var enabled bool
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// UseTOTPStep records that the code for the given time step has been used. Codes
+// are only accepted for steps later than the last one used, so that an intercepted
+// code cannot be replayed within its validity window; ErrTOTPReplay is returned
+// otherwise. Confirming an enrollment is done the same way, by passing confirm.
+func (m TwoFactorModel) UseTOTPStep(ctx context.Context, userID, step int64, confirm bool) error {
+	query := `
+		UPDATE users_totp
+		SET last_used_step = $2, confirmed_at = CASE WHEN $3 THEN NOW() ELSE confirmed_at END
+		WHERE user_id = $1 AND last_used_step < $2
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step, confirm)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTOTPReplay
+	}
+	return nil
+}
+
+// Disable removes the user's TOTP enrollment and recovery codes.
+func (m TwoFactorModel) Disable(ctx context.Context, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// NewRecoveryCodes replaces the user's recovery codes with a fresh set and returns
+// their plaintext. As with tokens, only the hashes are stored.
+func (m TwoFactorModel) NewRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		text := strings.ToLower(rand.Text()[:10])
+		codes[i] = text[:5] + "-" + text[5:]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, TokenHash(code), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// UseRecoveryCode consumes one of the user's unused recovery codes, returning
+// ErrRecordNotFound if the code does not match any of them.
+func (m TwoFactorModel) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE hash = $1 AND user_id = $2 AND used_at IS NULL
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	result, err := m.DB.ExecContext(ctx, query, TokenHash(code), userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -2,10 +2,14 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/vj-2303/voting-api-go/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -17,14 +21,16 @@ var (
 )
 
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	Role      string    `json:"role"`
-	Version   int       `json:"version"`
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Name        string     `json:"name"`
+	Email       string     `json:"email"`
+	Password    password   `json:"-"`
+	Activated   bool       `json:"activated"`
+	Roles       []string   `json:"roles"`
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	Version     int        `json:"version"`
 }
 
 type password struct {
@@ -43,6 +49,18 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// dummyPasswordHash is compared against when a login names an unknown email
+// address, so that the request costs as much as one for a real account. It is
+// generated up front so that the first such login is not slower than the rest.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte(rand.Text()), 12)
+
+// SimulatePasswordCheck spends the same time as checking a real password, without
+// any account. It keeps the response time of logins for unknown emails from
+// revealing whether an account exists.
+func SimulatePasswordCheck(plaintextPassword string) {
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(plaintextPassword))
+}
+
 func (p *password) Matches(plaintextPassword string) (bool, error) {
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 	if err != nil {
@@ -84,11 +102,20 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
+// IsDeleted reports whether the account has been erased at the user's request.
+func (u *User) IsDeleted() bool {
+	return u.DeletedAt != nil
+}
+
 type UserModel struct {
 	DB *sql.DB
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
 		VALUES ($1,$2,$3,$4)
@@ -99,7 +126,7 @@ func (m UserModel) Insert(user *User) error {
 		user.Name, user.Email, user.Password.hash, user.Activated,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -112,15 +139,17 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated,role, version
+		SELECT id, created_at, name, email, password_hash, activated, suspended_at, deleted_at, version,
+			ARRAY(SELECT roles.name FROM users_roles INNER JOIN roles ON roles.id = users_roles.role_id
+				WHERE users_roles.user_id = users.id ORDER BY roles.name)
 		FROM users
 		WHERE email = $1
 		  	 `
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -130,8 +159,10 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
-		&user.Role,
+		&user.SuspendedAt,
+		&user.DeletedAt,
 		&user.Version,
+		pq.Array(&user.Roles),
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -142,18 +173,20 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) GetByID(id int64) (*User, error) {
+func (m UserModel) GetByID(ctx context.Context, id int64) (*User, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-			SELECT id, created_at , name, email, password_hash, activated,role, version
+			SELECT id, created_at , name, email, password_hash, activated, suspended_at, deleted_at, version,
+				ARRAY(SELECT roles.name FROM users_roles INNER JOIN roles ON roles.id = users_roles.role_id
+					WHERE users_roles.user_id = users.id ORDER BY roles.name)
 		    FROM users
 			WHERE id = $1
 			 `
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
@@ -163,8 +196,247 @@ func (m UserModel) GetByID(id int64) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
-		&user.Role,
+		&user.SuspendedAt,
+		&user.DeletedAt,
+		&user.Version,
+		pq.Array(&user.Roles),
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (m UserModel) Update(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return updateUser(ctx, m.DB.QueryRowContext, user)
+}
+
+// UpdateByAdmin saves an administrator's change to a user, revokes the user's tokens
+// in revokeScopes and records the action, all in one transaction.
+func (m UserModel) UpdateByAdmin(ctx context.Context, user *User, revokeScopes []string, action *AdminAction) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateUser(ctx, tx.QueryRowContext, user)
+	if err != nil {
+		return err
+	}
+	if len(revokeScopes) > 0 {
+		query := `
+			DELETE FROM tokens
+			WHERE user_id = $1 AND scope = ANY($2)
+				 `
+		_, err = tx.ExecContext(ctx, query, user.ID, pq.Array(revokeScopes))
+		if err != nil {
+			return err
+		}
+	}
+	err = recordAdminAction(ctx, tx, action)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// updateUser saves a user through queryRow, which is either the pool's or a
+// transaction's QueryRowContext.
+func updateUser(ctx context.Context, queryRow func(ctx context.Context, query string, args ...any) *sql.Row, user *User) error {
+	query := `
+		UPDATE users
+		SET name = $1, email = $2, password_hash = $3, activated = $4, suspended_at = $5, version = version + 1
+		WHERE id = $6 AND version = $7
+		RETURNING version
+			 `
+	args := []any{
+		user.Name,
+		user.Email,
+		user.Password.hash,
+		user.Activated,
+		user.SuspendedAt,
+		user.ID,
+		user.Version,
+	}
+
+	err := queryRow(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "users_email_key"):
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAll returns a page of users. The name and email filters match substrings, role
+// matches users holding that role and activated, when not nil, matches the
+// activation state.
+func (m UserModel) GetAll(ctx context.Context, name, email, role string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, suspended_at, deleted_at, version,
+			ARRAY(SELECT roles.name FROM users_roles INNER JOIN roles ON roles.id = users_roles.role_id
+				WHERE users_roles.user_id = users.id ORDER BY roles.name)
+		FROM users
+		WHERE (name ILIKE '%%' || $1 || '%%' OR $1 = '')
+		AND (email ILIKE '%%' || $2 || '%%' OR $2 = '')
+		AND (EXISTS (
+				SELECT 1 FROM users_roles INNER JOIN roles ON roles.id = users_roles.role_id
+				WHERE users_roles.user_id = users.id AND roles.name = $3
+			) OR $3 = '')
+		AND (activated = $4::boolean OR $4::boolean IS NULL)
+		ORDER BY %s %s, id ASC
+		LIMIT $5 OFFSET $6
+			 `, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	args := []any{name, email, role, activated, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.SuspendedAt,
+			&user.DeletedAt,
+			&user.Version,
+			pq.Array(&user.Roles),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// Pseudonymize erases a user's personal data while keeping the account row, so that
+// their ballots still count towards poll tallies and the polls they created remain.
+// The name and email are replaced, the password is set to a random value nobody
+// knows, and tokens, roles and two-factor settings are deleted. Ballot history is
+// append-only and kept: it is keyed by the now pseudonymous user id. When an
+// administrator erases the account, action is recorded in the same transaction;
+// it is nil when users erase their own.
+func (m UserModel) Pseudonymize(ctx context.Context, id int64, action *AdminAction) error {
+	query := `
+		UPDATE users
+		SET name = 'Deleted user', email = 'deleted-' || id || '@invalid', password_hash = $2,
+			activated = false, deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL
+			 `
+	var unusable password
+	err := unusable.Set(rand.Text())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id, unusable.hash)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	for _, query := range []string{
+		`DELETE FROM tokens WHERE user_id = $1`,
+		`DELETE FROM users_roles WHERE user_id = $1`,
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM users_totp WHERE user_id = $1`,
+	} {
+		_, err = tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+	}
+	if action != nil {
+		err = recordAdminAction(ctx, tx, action)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetForToken returns the user that owns an unexpired token with the given scope.
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash,
+			users.activated, users.suspended_at, users.deleted_at, users.version,
+			ARRAY(SELECT roles.name FROM users_roles INNER JOIN roles ON roles.id = users_roles.role_id
+				WHERE users_roles.user_id = users.id ORDER BY roles.name)
+		FROM users
+		INNER JOIN tokens
+		ON users.id = tokens.user_id
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > $3
+			 `
+	args := []any{TokenHash(tokenPlaintext), tokenScope, time.Now()}
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.SuspendedAt,
+		&user.DeletedAt,
 		&user.Version,
+		pq.Array(&user.Roles),
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -4,9 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/vj-2303/voting-api-go/internal/validator"
 )
 
@@ -14,35 +18,136 @@ var (
 	ErrDuplicateVote = errors.New("user have already voted this poll")
 )
 
+// Vote is a single user's ballot on a poll. For single choice polls ChosenOptions
+// holds exactly one option; for multiple choice polls it holds every approved option
+// and for ranked polls it holds the ranked options, most preferred first. Score poll
+// ballots use Scores instead and ChosenOptions lists the scored options.
 type Vote struct {
-	ID           int64     `json:"id"`
-	PollID       int64     `json:"poll_id"`
-	UserID       int64     `json:"user_id"`
-	ChosenOption string    `json:"chosen_option"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID            int64          `json:"id"`
+	PollID        int64          `json:"poll_id"`
+	UserID        int64          `json:"user_id"`
+	ChosenOptions []string       `json:"chosen_options,omitempty"`
+	Scores        map[string]int `json:"scores,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
 }
 
-func ValidateVote(v *validator.Validator, chosenOption string, pollOptions []string) {
-	v.Check(chosenOption != "", "option", "must be provided")
-	v.Check(validator.In(chosenOption, pollOptions...), "option", "is not a valid poll option")
+func ValidateVote(v *validator.Validator, vote *Vote, poll *Poll) {
+	if poll.VotingMode == VotingModeScore {
+		v.Check(vote.ChosenOptions == nil, "options", "must not be provided for score polls, use scores instead")
+		validateScores(v, vote.Scores, poll)
+		return
+	}
+	v.Check(vote.Scores == nil, "scores", "must only be provided for score polls")
+	chosenOptions := vote.ChosenOptions
+
+	v.Check(len(chosenOptions) > 0, "options", "must be provided")
+	for _, option := range chosenOptions {
+		v.Check(validator.In(option, poll.Options...), "options", fmt.Sprintf("%q is not a valid poll option", option))
+	}
+	v.Check(validator.Unique(chosenOptions), "options", "must not contain duplicate values")
+
+	switch poll.VotingMode {
+	case VotingModeSingle:
+		v.Check(len(chosenOptions) == 1, "options", "must contain exactly one option")
+	case VotingModeMultiple, VotingModeRanked:
+		v.Check(len(chosenOptions) >= poll.MinChoices, "options", fmt.Sprintf("must contain at least %d options", poll.MinChoices))
+		v.Check(len(chosenOptions) <= poll.MaxChoices, "options", fmt.Sprintf("must not contain more than %d options", poll.MaxChoices))
+	}
+}
+
+func validateScores(v *validator.Validator, scores map[string]int, poll *Poll) {
+	v.Check(len(scores) > 0, "scores", "must be provided")
+	for option, score := range scores {
+		v.Check(validator.In(option, poll.Options...), "scores", fmt.Sprintf("%q is not a valid poll option", option))
+		v.Check(score >= *poll.ScoreMin && score <= *poll.ScoreMax, "scores",
+			fmt.Sprintf("must be between %d and %d", *poll.ScoreMin, *poll.ScoreMax))
+	}
+	for _, option := range poll.Options {
+		_, ok := scores[option]
+		v.Check(ok, "scores", fmt.Sprintf("must include a score for %q", option))
+	}
 }
 
+// scoreArray flattens a score ballot into the parallel chosen_options/scores arrays
+// stored in the database.
+func (vote *Vote) scoreArray() []int64 {
+	if vote.Scores == nil {
+		return nil
+	}
+	vote.ChosenOptions = slices.Sorted(maps.Keys(vote.Scores))
+	scores := make([]int64, len(vote.ChosenOptions))
+	for i, option := range vote.ChosenOptions {
+		scores[i] = int64(vote.Scores[option])
+	}
+	return scores
+}
+
+// setScores is the inverse of scoreArray.
+func (vote *Vote) setScores(scores []int64) {
+	if scores == nil {
+		return
+	}
+	vote.Scores = make(map[string]int, len(scores))
+	for i := range min(len(vote.ChosenOptions), len(scores)) {
+		vote.Scores[vote.ChosenOptions[i]] = int(scores[i])
+	}
+	vote.ChosenOptions = nil
+}
+
+// VoteHistoryEntry is an append-only audit record of a ballot being cast, changed or
+// retracted. For retractions the entry holds the ballot that was withdrawn.
+type VoteHistoryEntry struct {
+	ID            int64          `json:"id"`
+	PollID        int64          `json:"poll_id,omitempty"`
+	Action        string         `json:"action"`
+	ChosenOptions []string       `json:"chosen_options,omitempty"`
+	Scores        map[string]int `json:"scores,omitempty"`
+	RecordedAt    time.Time      `json:"recorded_at"`
+}
+
+const (
+	VoteActionCast      = "cast"
+	VoteActionChanged   = "changed"
+	VoteActionRetracted = "retracted"
+)
+
 type VotesModel struct {
 	DB *sql.DB
 }
 
-func (m VotesModel) Insert(vote *Vote) error {
+// recordHistory appends an entry to vote_history within the caller's transaction.
+func recordHistory(ctx context.Context, tx *sql.Tx, vote *Vote, action string, scores []int64) error {
 	query := `
-		INSERT INTO votes(poll_id,user_id,chosen_option)
-		VALUES($1,$2,$3)
+		INSERT INTO vote_history(poll_id, user_id, action, chosen_options, scores)
+		VALUES ($1,$2,$3,$4,$5)
+			 `
+	args := []any{vote.PollID, vote.UserID, action, pq.Array(vote.ChosenOptions), pq.Array(scores)}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (m VotesModel) Insert(ctx context.Context, vote *Vote) error {
+	query := `
+		INSERT INTO votes(poll_id,user_id,chosen_options,scores)
+		VALUES($1,$2,$3,$4)
 		RETURNING id, created_at
 			 `
-	args := []any{vote.PollID, vote.UserID, vote.ChosenOption}
+	// Score ballots are stored as two parallel arrays: the scored options and the
+	// score given to each.
+	scores := vote.scoreArray()
+	args := []any{vote.PollID, vote.UserID, pq.Array(vote.ChosenOptions), pq.Array(scores)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
 		&vote.ID,
 		&vote.CreatedAt,
 	)
@@ -52,5 +157,251 @@ func (m VotesModel) Insert(vote *Vote) error {
 		}
 		return err
 	}
-	return nil
+	err = recordHistory(ctx, tx, vote, VoteActionCast, scores)
+	if err != nil {
+		return err
+	}
+	if vote.Scores != nil {
+		vote.ChosenOptions = nil
+	}
+	return tx.Commit()
+}
+
+func (m VotesModel) GetForUser(ctx context.Context, pollID, userID int64) (*Vote, error) {
+	query := `
+		SELECT id, poll_id, user_id, chosen_options, scores, created_at
+		FROM votes
+		WHERE poll_id = $1 AND user_id = $2
+			 `
+	var vote Vote
+	var scores []int64
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, pollID, userID).Scan(
+		&vote.ID,
+		&vote.PollID,
+		&vote.UserID,
+		pq.Array(&vote.ChosenOptions),
+		pq.Array(&scores),
+		&vote.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	vote.setScores(scores)
+	return &vote, nil
+}
+
+// Replace overwrites the user's existing ballot on the poll, recording the new
+// ballot in the history.
+func (m VotesModel) Replace(ctx context.Context, vote *Vote) error {
+	query := `
+		UPDATE votes
+		SET chosen_options = $1, scores = $2
+		WHERE poll_id = $3 AND user_id = $4
+		RETURNING id, created_at
+			 `
+	scores := vote.scoreArray()
+	args := []any{pq.Array(vote.ChosenOptions), pq.Array(scores), vote.PollID, vote.UserID}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&vote.ID, &vote.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	err = recordHistory(ctx, tx, vote, VoteActionChanged, scores)
+	if err != nil {
+		return err
+	}
+	if vote.Scores != nil {
+		vote.ChosenOptions = nil
+	}
+	return tx.Commit()
+}
+
+// Delete retracts the user's ballot on the poll, keeping a copy of it in the history.
+func (m VotesModel) Delete(ctx context.Context, pollID, userID int64) error {
+	query := `
+		DELETE FROM votes
+		WHERE poll_id = $1 AND user_id = $2
+		RETURNING chosen_options, scores
+			 `
+	vote := &Vote{PollID: pollID, UserID: userID}
+	var scores []int64
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, pollID, userID).Scan(pq.Array(&vote.ChosenOptions), pq.Array(&scores))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	err = recordHistory(ctx, tx, vote, VoteActionRetracted, scores)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetHistory returns every recorded ballot action of a user on a poll, oldest first.
+func (m VotesModel) GetHistory(ctx context.Context, pollID, userID int64) ([]*VoteHistoryEntry, error) {
+	query := `
+		SELECT id, action, chosen_options, scores, recorded_at
+		FROM vote_history
+		WHERE poll_id = $1 AND user_id = $2
+		ORDER BY id
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pollID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []*VoteHistoryEntry{}
+
+	for rows.Next() {
+		var entry VoteHistoryEntry
+		var scores []int64
+		err := rows.Scan(&entry.ID, &entry.Action, pq.Array(&entry.ChosenOptions), pq.Array(&scores), &entry.RecordedAt)
+		if err != nil {
+			return nil, err
+		}
+		ballot := Vote{ChosenOptions: entry.ChosenOptions}
+		ballot.setScores(scores)
+		entry.ChosenOptions, entry.Scores = ballot.ChosenOptions, ballot.Scores
+
+		history = append(history, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// GetAllForUser returns every current ballot of a user across all polls.
+func (m VotesModel) GetAllForUser(ctx context.Context, userID int64) ([]*Vote, error) {
+	query := `
+		SELECT id, poll_id, user_id, chosen_options, scores, created_at
+		FROM votes
+		WHERE user_id = $1
+		ORDER BY id
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	votes := []*Vote{}
+
+	for rows.Next() {
+		var vote Vote
+		var scores []int64
+		err := rows.Scan(&vote.ID, &vote.PollID, &vote.UserID, pq.Array(&vote.ChosenOptions), pq.Array(&scores), &vote.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		vote.setScores(scores)
+		votes = append(votes, &vote)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return votes, nil
+}
+
+// GetAllHistoryForUser returns every recorded ballot action of a user across all
+// polls, oldest first.
+func (m VotesModel) GetAllHistoryForUser(ctx context.Context, userID int64) ([]*VoteHistoryEntry, error) {
+	query := `
+		SELECT id, poll_id, action, chosen_options, scores, recorded_at
+		FROM vote_history
+		WHERE user_id = $1
+		ORDER BY id
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []*VoteHistoryEntry{}
+
+	for rows.Next() {
+		var entry VoteHistoryEntry
+		var scores []int64
+		err := rows.Scan(&entry.ID, &entry.PollID, &entry.Action, pq.Array(&entry.ChosenOptions), pq.Array(&scores), &entry.RecordedAt)
+		if err != nil {
+			return nil, err
+		}
+		ballot := Vote{ChosenOptions: entry.ChosenOptions}
+		ballot.setScores(scores)
+		entry.ChosenOptions, entry.Scores = ballot.ChosenOptions, ballot.Scores
+
+		history = append(history, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// HasVoted reports whether the user currently has a ballot on the given poll.
+func (m VotesModel) HasVoted(ctx context.Context, pollID, userID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (SELECT 1 FROM votes WHERE poll_id = $1 AND user_id = $2)
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, pollID, userID).Scan(&exists)
+	return exists, err
+}
+
+// HasVotes reports whether at least one vote has been cast on the given poll.
+func (m VotesModel) HasVotes(ctx context.Context, pollID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (SELECT 1 FROM votes WHERE poll_id = $1)
+			 `
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, pollID).Scan(&exists)
+	return exists, err
 }
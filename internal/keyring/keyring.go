@@ -0,0 +1,214 @@
+// Package keyring holds the keys used to sign and verify JWTs. Exactly one key is
+// active for signing at a time, while any number of older keys can remain trusted for
+// verification so that tokens survive a key rotation until they expire.
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MinSecretLength is the shortest HMAC secret accepted, matching the 256-bit output
+// of HS256.
+const MinSecretLength = 32
+
+var (
+	ErrWeakSecret   = fmt.Errorf("JWT secret must be at least %d bytes long", MinSecretLength)
+	ErrUnknownKey   = errors.New("unknown signing key")
+	ErrNoSigningKey = errors.New("no signing key configured")
+)
+
+// Key is a single signing or verification key.
+type Key struct {
+	ID     string
+	method jwt.SigningMethod
+	// signKey is nil for keys that can only be used for verification.
+	signKey   any
+	verifyKey any
+}
+
+// Algorithm returns the JWS algorithm name of the key, e.g. "RS256".
+func (k *Key) Algorithm() string {
+	return k.method.Alg()
+}
+
+// CanSign reports whether the key holds private material.
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// NewHMAC creates an HS256 key from a shared secret, refusing secrets that are too
+// short to be safe.
+func NewHMAC(id, secret string) (*Key, error) {
+	if len(secret) < MinSecretLength {
+		return nil, ErrWeakSecret
+	}
+	key := &Key{
+		ID:        id,
+		method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+	return key, nil
+}
+
+// LoadPEM reads an RSA or Ed25519 key from a PEM file. Private keys (PKCS #1 or
+// PKCS #8) can sign and verify; public keys (PKIX) can only verify.
+func LoadPEM(id, path string) (*Key, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block type %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key := &Key{ID: id}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.signKey, key.verifyKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.method, key.verifyKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.method, key.signKey, key.verifyKey = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.method, key.verifyKey = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, parsed)
+	}
+	if rsaKey, ok := key.verifyKey.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("%s: RSA keys must be at least 2048 bits", path)
+	}
+	return key, nil
+}
+
+// Keyring is the set of trusted keys plus the one currently used for signing.
+type Keyring struct {
+	active *Key
+	keys   map[string]*Key
+}
+
+// New builds a keyring from keys, using the key identified by activeID for signing.
+func New(activeID string, keys ...*Key) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string]*Key, len(keys))}
+
+	for _, key := range keys {
+		if _, exists := kr.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		kr.keys[key.ID] = key
+	}
+	active, ok := kr.keys[activeID]
+	if !ok {
+		return nil, ErrNoSigningKey
+	}
+	if !active.CanSign() {
+		return nil, fmt.Errorf("key %q cannot be used for signing: no private key", activeID)
+	}
+	kr.active = active
+	return kr, nil
+}
+
+// Sign signs claims with the active key, recording its id in the "kid" header.
+func (kr *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(kr.active.method, claims)
+	token.Header["kid"] = kr.active.ID
+
+	return token.SignedString(kr.active.signKey)
+}
+
+// Keyfunc resolves the verification key for a token from its "kid" header. The
+// token's algorithm must match the key's, which rules out algorithm confusion such
+// as an RSA public key being used as an HMAC secret. It is meant to be passed to
+// jwt.Parse.
+func (kr *Keyring) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := kr.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Algorithm() {
+		return nil, fmt.Errorf("unexpected signing method %q for key %q", token.Method.Alg(), kid)
+	}
+	return key.verifyKey, nil
+}
+
+// Algorithms lists the algorithms of every trusted key, for jwt.WithValidMethods.
+func (kr *Keyring) Algorithms() []string {
+	algs := []string{}
+	for _, key := range kr.keys {
+		if !slices.Contains(algs, key.Algorithm()) {
+			algs = append(algs, key.Algorithm())
+		}
+	}
+	return algs
+}
+
+// JWK is the JSON Web Key representation of a public key (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS returns the public half of every asymmetric key, sorted by key id. HMAC keys
+// are shared secrets and are never published.
+func (kr *Keyring) JWKS() []JWK {
+	enc := base64.RawURLEncoding
+	jwks := []JWK{}
+
+	for _, key := range kr.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm()}
+
+		switch k := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = enc.EncodeToString(k.N.Bytes())
+			jwk.E = enc.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = enc.EncodeToString(k)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	slices.SortFunc(jwks, func(a, b JWK) int {
+		return strings.Compare(a.KeyID, b.KeyID)
+	})
+	return jwks
+}
@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LogMailer does not deliver email. It logs that each message was sent and, if dir
+// is set, writes it to a .eml file in that directory so it can be inspected or read
+// back in tests and local development. Message bodies carry live activation and
+// password reset tokens, so they are never written to the log.
+type LogMailer struct {
+	logger *slog.Logger
+	dir    string
+	sender string
+}
+
+func NewLog(logger *slog.Logger, dir, sender string) *LogMailer {
+	return &LogMailer{
+		logger: logger,
+		dir:    dir,
+		sender: sender,
+	}
+}
+
+func (m *LogMailer) Send(recipient, templateFile string, data any) error {
+	msg, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	attrs := []any{"to", msg.To, "subject", msg.Subject}
+
+	if m.dir != "" {
+		body, err := msg.bytes()
+		if err != nil {
+			return err
+		}
+		// The recipient is only ever written inside the file: email addresses may
+		// contain path separators.
+		name := fmt.Sprintf("%d-%s-%s.eml", time.Now().UnixNano(), strings.TrimSuffix(templateFile, ".tmpl"), rand.Text())
+		path := filepath.Join(m.dir, name)
+
+		err = os.WriteFile(path, body, 0o600)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, "file", path)
+	}
+	m.logger.Info("email sent to log sink", attrs...)
+	return nil
+}
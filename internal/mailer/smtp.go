@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer delivers email through an SMTP server.
+type SMTPMailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+	// envelopeSender is the bare address from sender, as used in MAIL FROM.
+	envelopeSender string
+}
+
+// NewSMTP returns a mailer sending as sender, which may include a display name such
+// as "Voting API <no-reply@example.com>".
+func NewSMTP(host string, port int, username, password, sender string) (*SMTPMailer, error) {
+	addr, err := mail.ParseAddress(sender)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", sender, err)
+	}
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		addr:           fmt.Sprintf("%s:%d", host, port),
+		auth:           auth,
+		sender:         sender,
+		envelopeSender: addr.Address,
+	}, nil
+}
+
+func (m *SMTPMailer) Send(recipient, templateFile string, data any) error {
+	msg, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+	body, err := msg.bytes()
+	if err != nil {
+		return err
+	}
+
+	// Try sending the email up to three times before aborting and returning the
+	// final error, sleeping briefly between attempts.
+	for i := 1; i <= 3; i++ {
+		err = smtp.SendMail(m.addr, m.auth, m.envelopeSender, []string{recipient}, body)
+		if err == nil {
+			return nil
+		}
+		if i != 3 {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+	return err
+}
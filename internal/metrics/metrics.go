@@ -0,0 +1,299 @@
+// Package metrics implements the small subset of Prometheus metric types the API
+// needs, counters, histograms and gauges read on demand, and renders them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram bucket upper bounds, in seconds, suited to HTTP
+// request latencies.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds a set of metrics and writes them out together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (reg *Registry) register(c collector) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.metrics = append(reg.metrics, c)
+}
+
+// WriteTo writes every registered metric in the Prometheus text format.
+func (reg *Registry) WriteTo(w io.Writer) (int64, error) {
+	reg.mu.Lock()
+	metrics := slices.Clone(reg.metrics)
+	reg.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// desc is the name, help text and label names shared by every series of a metric.
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d desc) header(w *bufio.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, kind)
+}
+
+// labelKey joins label values into a map key. The separator cannot appear in
+// valid UTF-8 text.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// labelString renders a label set, with an optional extra label appended, as
+// {name="value",...}.
+func (d desc) labelString(values []string, extraName, extraValue string) string {
+	var b strings.Builder
+	escape := strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+	for i, name := range d.labels {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, escape.Replace(values[i]))
+	}
+	if extraName != "" {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extraName, extraValue)
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "{" + b.String() + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// CounterVec is a counter partitioned by a set of labels. A CounterVec created
+// without labels is a plain counter, used through With().
+type CounterVec struct {
+	desc
+
+	mu     sync.Mutex
+	series map[string]*Counter
+}
+
+type Counter struct {
+	mu     sync.Mutex
+	values []string
+	value  float64
+}
+
+func (reg *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name, help, labels}, series: make(map[string]*Counter)}
+	reg.register(c)
+	return c
+}
+
+// With returns the counter for the given label values, which must match the
+// label names in number and order.
+func (c *CounterVec) With(values ...string) *Counter {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", c.name, len(c.labels), len(values)))
+	}
+	key := labelKey(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &Counter{values: slices.Clone(values)}
+		c.series[key] = s
+	}
+	return s
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter by n, which must not be negative.
+func (c *Counter) Add(n float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value += n
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.header(w, "counter")
+
+	c.mu.Lock()
+	keys := slices.Sorted(maps.Keys(c.series))
+	series := make([]*Counter, len(keys))
+	for i, key := range keys {
+		series[i] = c.series[key]
+	}
+	c.mu.Unlock()
+
+	for _, s := range series {
+		s.mu.Lock()
+		value := s.value
+		s.mu.Unlock()
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(s.values, "", ""), formatFloat(value))
+	}
+}
+
+// HistogramVec is a histogram partitioned by a set of labels.
+type HistogramVec struct {
+	desc
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*Histogram
+}
+
+type Histogram struct {
+	mu      sync.Mutex
+	values  []string
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (reg *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name, help, labels},
+		buckets: slices.Sorted(slices.Values(buckets)),
+		series:  make(map[string]*Histogram),
+	}
+	reg.register(h)
+	return h
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram {
+	if len(values) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", h.name, len(h.labels), len(values)))
+	}
+	key := labelKey(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &Histogram{values: slices.Clone(values), buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	return s
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.header(w, "histogram")
+
+	h.mu.Lock()
+	keys := slices.Sorted(maps.Keys(h.series))
+	series := make([]*Histogram, len(keys))
+	for i, key := range keys {
+		series[i] = h.series[key]
+	}
+	h.mu.Unlock()
+
+	for _, s := range series {
+		s.mu.Lock()
+		counts, count, sum := slices.Clone(s.counts), s.count, s.sum
+		s.mu.Unlock()
+
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(s.values, "le", formatFloat(bound)), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(s.values, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(s.values, "", ""), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(s.values, "", ""), count)
+	}
+}
+
+// GaugeFunc is a gauge whose value is read from a function each time the metrics
+// are written, for values such as connection pool statistics that are tracked
+// elsewhere.
+type GaugeFunc struct {
+	desc
+	kind string
+	fn   func() float64
+}
+
+func (reg *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help}, kind: "gauge", fn: fn}
+	reg.register(g)
+	return g
+}
+
+// NewCounterFunc is like NewGaugeFunc for values that only ever increase.
+func (reg *Registry) NewCounterFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help}, kind: "counter", fn: fn}
+	reg.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.header(w, g.kind)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
@@ -0,0 +1,130 @@
+// Package tally implements the counting methods used to turn stored ballots into
+// poll results. The functions here are pure: they take the poll options and the
+// ballots and do not touch the database.
+package tally
+
+import (
+	"slices"
+)
+
+// RunoffRound describes a single round of an instant-runoff count.
+type RunoffRound struct {
+	Round int `json:"round"`
+	// Tallies holds the number of ballots counting towards each continuing option.
+	Tallies map[string]int `json:"tallies"`
+	// Exhausted is the cumulative number of ballots with no continuing preferences.
+	Exhausted  int      `json:"exhausted"`
+	Eliminated []string `json:"eliminated,omitempty"`
+	// Transfers records where the ballots of the eliminated options went at the end
+	// of this round; TransfersExhausted counts those that had no further preference.
+	Transfers          map[string]int `json:"transfers,omitempty"`
+	TransfersExhausted int            `json:"transfers_exhausted,omitempty"`
+}
+
+// RunoffResult is the full round-by-round breakdown of an instant-runoff count.
+// Winner is empty if there were no ballots or the final continuing options tied.
+type RunoffResult struct {
+	TotalBallots int           `json:"total_ballots"`
+	Rounds       []RunoffRound `json:"rounds"`
+	Winner       string        `json:"winner,omitempty"`
+	Tied         []string      `json:"tied,omitempty"`
+}
+
+// InstantRunoff counts ranked ballots using instant-runoff voting. Each ballot lists
+// options in order of preference, most preferred first, and need not rank every option.
+// In each round the ballots count for their highest ranked continuing option; an option
+// with a majority of the non-exhausted ballots wins, otherwise every option sharing the
+// lowest tally is eliminated and its ballots transfer to their next preference.
+func InstantRunoff(options []string, ballots [][]string) RunoffResult {
+	result := RunoffResult{
+		TotalBallots: len(ballots),
+		Rounds:       []RunoffRound{},
+	}
+
+	continuing := make(map[string]bool, len(options))
+	for _, option := range options {
+		continuing[option] = true
+	}
+
+	// top returns the highest ranked continuing option on a ballot, or "" if the
+	// ballot is exhausted.
+	top := func(ballot []string) string {
+		for _, option := range ballot {
+			if continuing[option] {
+				return option
+			}
+		}
+		return ""
+	}
+
+	for round := 1; len(continuing) > 0; round++ {
+		current := RunoffRound{
+			Round:   round,
+			Tallies: make(map[string]int, len(continuing)),
+		}
+		for option := range continuing {
+			current.Tallies[option] = 0
+		}
+		for _, ballot := range ballots {
+			if choice := top(ballot); choice != "" {
+				current.Tallies[choice]++
+			} else {
+				current.Exhausted++
+			}
+		}
+
+		active := len(ballots) - current.Exhausted
+		if active == 0 {
+			result.Rounds = append(result.Rounds, current)
+			return result
+		}
+
+		lowest := -1
+		for option, count := range current.Tallies {
+			if count*2 > active || len(continuing) == 1 {
+				result.Rounds = append(result.Rounds, current)
+				result.Winner = option
+				return result
+			}
+			if lowest == -1 || count < lowest {
+				lowest = count
+			}
+		}
+
+		for option, count := range current.Tallies {
+			if count == lowest {
+				current.Eliminated = append(current.Eliminated, option)
+			}
+		}
+		slices.Sort(current.Eliminated)
+
+		// Eliminating every continuing option would leave nobody to win, so the
+		// count ends in a tie between them instead.
+		if len(current.Eliminated) == len(continuing) {
+			result.Rounds = append(result.Rounds, current)
+			result.Tied = current.Eliminated
+			return result
+		}
+
+		current.Transfers = make(map[string]int)
+		eliminatedBallots := make([][]string, 0)
+		for _, ballot := range ballots {
+			if slices.Contains(current.Eliminated, top(ballot)) {
+				eliminatedBallots = append(eliminatedBallots, ballot)
+			}
+		}
+		for _, option := range current.Eliminated {
+			delete(continuing, option)
+		}
+		for _, ballot := range eliminatedBallots {
+			if next := top(ballot); next != "" {
+				current.Transfers[next]++
+			} else {
+				current.TransfersExhausted++
+			}
+		}
+
+		result.Rounds = append(result.Rounds, current)
+	}
+	return result
+}
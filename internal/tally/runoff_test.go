@@ -0,0 +1,132 @@
+package tally
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestInstantRunoff(t *testing.T) {
+	options := []string{"alice", "bob", "carol"}
+
+	tests := []struct {
+		name       string
+		ballots    [][]string
+		wantWinner string
+		wantTied   []string
+		wantRounds int
+	}{
+		{
+			name:       "no ballots",
+			ballots:    nil,
+			wantRounds: 1,
+		},
+		{
+			name: "first round majority",
+			ballots: [][]string{
+				{"alice", "bob"},
+				{"alice"},
+				{"bob", "alice"},
+			},
+			wantWinner: "alice",
+			wantRounds: 1,
+		},
+		{
+			name: "transfers decide the winner",
+			ballots: [][]string{
+				{"alice"},
+				{"alice"},
+				{"bob", "carol"},
+				{"bob", "carol"},
+				{"carol", "bob"},
+			},
+			wantWinner: "bob",
+			wantRounds: 2,
+		},
+		{
+			name: "exhausted ballots do not count towards the majority",
+			ballots: [][]string{
+				{"alice"},
+				{"alice"},
+				{"bob"},
+				{"carol"},
+			},
+			wantWinner: "alice",
+			wantRounds: 2,
+		},
+		{
+			name: "unbreakable tie",
+			ballots: [][]string{
+				{"alice"},
+				{"bob"},
+			},
+			wantTied:   []string{"alice", "bob"},
+			wantRounds: 2,
+		},
+		{
+			name: "unknown options are ignored",
+			ballots: [][]string{
+				{"dave", "carol"},
+				{"carol"},
+				{"alice"},
+			},
+			wantWinner: "carol",
+			wantRounds: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := InstantRunoff(options, tt.ballots)
+
+			if got.TotalBallots != len(tt.ballots) {
+				t.Errorf("TotalBallots = %d, want %d", got.TotalBallots, len(tt.ballots))
+			}
+			if got.Winner != tt.wantWinner {
+				t.Errorf("Winner = %q, want %q", got.Winner, tt.wantWinner)
+			}
+			if !slices.Equal(got.Tied, tt.wantTied) {
+				t.Errorf("Tied = %v, want %v", got.Tied, tt.wantTied)
+			}
+			if len(got.Rounds) != tt.wantRounds {
+				t.Errorf("got %d rounds, want %d", len(got.Rounds), tt.wantRounds)
+			}
+		})
+	}
+}
+
+func TestInstantRunoffTransfers(t *testing.T) {
+	options := []string{"alice", "bob", "carol"}
+	ballots := [][]string{
+		{"alice"},
+		{"alice"},
+		{"alice"},
+		{"bob"},
+		{"bob"},
+		{"bob"},
+		{"carol", "bob"},
+		{"carol"},
+	}
+
+	got := InstantRunoff(options, ballots)
+
+	if len(got.Rounds) != 2 {
+		t.Fatalf("got %d rounds, want 2", len(got.Rounds))
+	}
+	first, second := got.Rounds[0], got.Rounds[1]
+
+	if !slices.Equal(first.Eliminated, []string{"carol"}) {
+		t.Errorf("round 1 eliminated %v, want [carol]", first.Eliminated)
+	}
+	if first.Transfers["bob"] != 1 || first.TransfersExhausted != 1 {
+		t.Errorf("round 1 transfers = %v, %d exhausted; want bob: 1, 1 exhausted", first.Transfers, first.TransfersExhausted)
+	}
+	if second.Tallies["alice"] != 3 || second.Tallies["bob"] != 4 {
+		t.Errorf("round 2 tallies = %v, want alice: 3, bob: 4", second.Tallies)
+	}
+	if second.Exhausted != 1 {
+		t.Errorf("round 2 exhausted = %d, want 1", second.Exhausted)
+	}
+	if got.Winner != "bob" {
+		t.Errorf("Winner = %q, want bob", got.Winner)
+	}
+}
@@ -0,0 +1,131 @@
+package tally
+
+import (
+	"slices"
+)
+
+// SchulzeResult is the outcome of a Schulze count, including the intermediate
+// matrices so that the result can be independently verified.
+type SchulzeResult struct {
+	// Pairwise[a][b] is the number of ballots that prefer a over b.
+	Pairwise map[string]map[string]int `json:"pairwise"`
+	// StrongestPaths[a][b] is the strength of the strongest path from a to b.
+	StrongestPaths map[string]map[string]int `json:"strongest_paths"`
+	// Ranking orders the options from best to worst. Options sharing a tier are tied.
+	Ranking         [][]string `json:"ranking"`
+	Winners         []string   `json:"winners"`
+	CondorcetWinner string     `json:"condorcet_winner,omitempty"`
+}
+
+// Schulze counts ranked ballots using the Schulze method. A ballot prefers every
+// option it ranks over every option it leaves unranked; unranked options are treated
+// as tied with each other.
+func Schulze(options []string, ballots [][]string) SchulzeResult {
+	n := len(options)
+	index := make(map[string]int, n)
+	for i, option := range options {
+		index[option] = i
+	}
+
+	d := make([][]int, n)
+	for i := range d {
+		d[i] = make([]int, n)
+	}
+
+	for _, ballot := range ballots {
+		// rank[i] is the position of option i on the ballot, or n if unranked.
+		rank := make([]int, n)
+		for i := range rank {
+			rank[i] = n
+		}
+		for position, option := range ballot {
+			if i, ok := index[option]; ok && rank[i] == n {
+				rank[i] = position
+			}
+		}
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if i != j && rank[i] < rank[j] {
+					d[i][j]++
+				}
+			}
+		}
+	}
+
+	// Widest path computation (a Floyd–Warshall variant) over the defeats.
+	p := make([][]int, n)
+	for i := range p {
+		p[i] = make([]int, n)
+		for j := 0; j < n; j++ {
+			if i != j && d[i][j] > d[j][i] {
+				p[i][j] = d[i][j]
+			}
+		}
+	}
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			if i == k {
+				continue
+			}
+			for j := 0; j < n; j++ {
+				if j == i || j == k {
+					continue
+				}
+				p[i][j] = max(p[i][j], min(p[i][k], p[k][j]))
+			}
+		}
+	}
+
+	result := SchulzeResult{
+		Pairwise:       make(map[string]map[string]int, n),
+		StrongestPaths: make(map[string]map[string]int, n),
+		Ranking:        [][]string{},
+		Winners:        []string{},
+	}
+
+	wins := make([]int, n)
+	for i, a := range options {
+		result.Pairwise[a] = make(map[string]int, n-1)
+		result.StrongestPaths[a] = make(map[string]int, n-1)
+
+		beatsAllPairwise := n > 1
+		for j, b := range options {
+			if i == j {
+				continue
+			}
+			result.Pairwise[a][b] = d[i][j]
+			result.StrongestPaths[a][b] = p[i][j]
+			if p[i][j] > p[j][i] {
+				wins[i]++
+			}
+			if d[i][j] <= d[j][i] {
+				beatsAllPairwise = false
+			}
+		}
+		if beatsAllPairwise {
+			result.CondorcetWinner = a
+		}
+	}
+
+	// The Schulze relation is transitive, so ordering options by how many others
+	// they beat yields a ranking consistent with it.
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return wins[b] - wins[a]
+	})
+	for k, i := range order {
+		if k > 0 && wins[i] == wins[order[k-1]] {
+			last := len(result.Ranking) - 1
+			result.Ranking[last] = append(result.Ranking[last], options[i])
+			continue
+		}
+		result.Ranking = append(result.Ranking, []string{options[i]})
+	}
+	if len(result.Ranking) > 0 {
+		result.Winners = result.Ranking[0]
+	}
+	return result
+}
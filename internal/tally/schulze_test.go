@@ -0,0 +1,127 @@
+package tally
+
+import (
+	"reflect"
+	"testing"
+)
+
+// repeat returns n copies of a ballot.
+func repeat(n int, ballot ...string) [][]string {
+	ballots := make([][]string, n)
+	for i := range ballots {
+		ballots[i] = ballot
+	}
+	return ballots
+}
+
+func TestSchulze(t *testing.T) {
+	// The 45-voter example used to illustrate the method on Wikipedia, which has no
+	// Condorcet winner.
+	var wikipedia [][]string
+	for _, group := range []struct {
+		n      int
+		ballot []string
+	}{
+		{5, []string{"A", "C", "B", "E", "D"}},
+		{5, []string{"A", "D", "E", "C", "B"}},
+		{8, []string{"B", "E", "D", "A", "C"}},
+		{3, []string{"C", "A", "B", "E", "D"}},
+		{7, []string{"C", "A", "E", "B", "D"}},
+		{2, []string{"C", "B", "A", "D", "E"}},
+		{7, []string{"D", "C", "E", "B", "A"}},
+		{8, []string{"E", "B", "A", "D", "C"}},
+	} {
+		wikipedia = append(wikipedia, repeat(group.n, group.ballot...)...)
+	}
+
+	tests := []struct {
+		name          string
+		options       []string
+		ballots       [][]string
+		wantRanking   [][]string
+		wantWinners   []string
+		wantCondorcet string
+	}{
+		{
+			name:        "no ballots",
+			options:     []string{"A", "B"},
+			ballots:     nil,
+			wantRanking: [][]string{{"A", "B"}},
+			wantWinners: []string{"A", "B"},
+		},
+		{
+			name:        "wikipedia example",
+			options:     []string{"A", "B", "C", "D", "E"},
+			ballots:     wikipedia,
+			wantRanking: [][]string{{"E"}, {"A"}, {"C"}, {"B"}, {"D"}},
+			wantWinners: []string{"E"},
+		},
+		{
+			name:    "condorcet winner",
+			options: []string{"A", "B", "C"},
+			ballots: append(append(
+				repeat(3, "A", "B", "C"),
+				repeat(2, "B", "A", "C")...),
+				repeat(2, "C", "A", "B")...),
+			wantRanking:   [][]string{{"A"}, {"B"}, {"C"}},
+			wantWinners:   []string{"A"},
+			wantCondorcet: "A",
+		},
+		{
+			name:          "unranked options count as last",
+			options:       []string{"A", "B", "C"},
+			ballots:       [][]string{{"B"}, {"B"}, {"A", "C"}},
+			wantRanking:   [][]string{{"B"}, {"A"}, {"C"}},
+			wantWinners:   []string{"B"},
+			wantCondorcet: "B",
+		},
+		{
+			name:        "symmetric tie",
+			options:     []string{"A", "B"},
+			ballots:     [][]string{{"A", "B"}, {"B", "A"}},
+			wantRanking: [][]string{{"A", "B"}},
+			wantWinners: []string{"A", "B"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Schulze(tt.options, tt.ballots)
+
+			if !reflect.DeepEqual(got.Ranking, tt.wantRanking) {
+				t.Errorf("Ranking = %v, want %v", got.Ranking, tt.wantRanking)
+			}
+			if !reflect.DeepEqual(got.Winners, tt.wantWinners) {
+				t.Errorf("Winners = %v, want %v", got.Winners, tt.wantWinners)
+			}
+			if got.CondorcetWinner != tt.wantCondorcet {
+				t.Errorf("CondorcetWinner = %q, want %q", got.CondorcetWinner, tt.wantCondorcet)
+			}
+		})
+	}
+}
+
+func TestSchulzeMatrices(t *testing.T) {
+	options := []string{"A", "B", "C"}
+	ballots := append(repeat(2, "A", "B", "C"), repeat(1, "C", "B", "A")...)
+
+	got := Schulze(options, ballots)
+
+	wantPairwise := map[string]map[string]int{
+		"A": {"B": 2, "C": 2},
+		"B": {"A": 1, "C": 2},
+		"C": {"A": 1, "B": 1},
+	}
+	if !reflect.DeepEqual(got.Pairwise, wantPairwise) {
+		t.Errorf("Pairwise = %v, want %v", got.Pairwise, wantPairwise)
+	}
+	// Only defeats form paths, so the losing side of each pair has strength zero.
+	wantPaths := map[string]map[string]int{
+		"A": {"B": 2, "C": 2},
+		"B": {"A": 0, "C": 2},
+		"C": {"A": 0, "B": 0},
+	}
+	if !reflect.DeepEqual(got.StrongestPaths, wantPaths) {
+		t.Errorf("StrongestPaths = %v, want %v", got.StrongestPaths, wantPaths)
+	}
+}
@@ -0,0 +1,55 @@
+package tally
+
+import (
+	"slices"
+)
+
+// ScoreSummary describes the scores given to a single option in a score poll.
+type ScoreSummary struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	// Distribution maps each possible score to the number of ballots that gave it.
+	Distribution map[int]int `json:"distribution"`
+}
+
+// Scores summarises score (range) ballots. Each ballot maps options to the score the
+// voter gave them; every score is expected to lie between minScore and maxScore.
+func Scores(options []string, minScore, maxScore int, ballots []map[string]int) map[string]ScoreSummary {
+	given := make(map[string][]int, len(options))
+	for _, ballot := range ballots {
+		for option, score := range ballot {
+			given[option] = append(given[option], score)
+		}
+	}
+
+	summaries := make(map[string]ScoreSummary, len(options))
+	for _, option := range options {
+		scores := given[option]
+		summary := ScoreSummary{
+			Count:        len(scores),
+			Distribution: make(map[int]int, maxScore-minScore+1),
+		}
+		for score := minScore; score <= maxScore; score++ {
+			summary.Distribution[score] = 0
+		}
+		if len(scores) > 0 {
+			slices.Sort(scores)
+			total := 0
+			for _, score := range scores {
+				total += score
+				summary.Distribution[score]++
+			}
+			summary.Mean = float64(total) / float64(len(scores))
+
+			middle := len(scores) / 2
+			if len(scores)%2 == 0 {
+				summary.Median = float64(scores[middle-1]+scores[middle]) / 2
+			} else {
+				summary.Median = float64(scores[middle])
+			}
+		}
+		summaries[option] = summary
+	}
+	return summaries
+}
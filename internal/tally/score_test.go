@@ -0,0 +1,60 @@
+package tally
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScores(t *testing.T) {
+	options := []string{"red", "green"}
+
+	tests := []struct {
+		name    string
+		ballots []map[string]int
+		want    map[string]ScoreSummary
+	}{
+		{
+			name:    "no ballots",
+			ballots: nil,
+			want: map[string]ScoreSummary{
+				"red":   {Distribution: map[int]int{0: 0, 1: 0, 2: 0, 3: 0}},
+				"green": {Distribution: map[int]int{0: 0, 1: 0, 2: 0, 3: 0}},
+			},
+		},
+		{
+			name: "odd number of scores",
+			ballots: []map[string]int{
+				{"red": 3, "green": 0},
+				{"red": 1, "green": 0},
+				{"red": 2, "green": 3},
+			},
+			want: map[string]ScoreSummary{
+				"red":   {Count: 3, Mean: 2, Median: 2, Distribution: map[int]int{0: 0, 1: 1, 2: 1, 3: 1}},
+				"green": {Count: 3, Mean: 1, Median: 0, Distribution: map[int]int{0: 2, 1: 0, 2: 0, 3: 1}},
+			},
+		},
+		{
+			name: "even number of scores averages the middle two",
+			ballots: []map[string]int{
+				{"red": 0},
+				{"red": 3},
+				{"red": 1},
+				{"red": 3},
+			},
+			want: map[string]ScoreSummary{
+				"red":   {Count: 4, Mean: 1.75, Median: 2, Distribution: map[int]int{0: 1, 1: 1, 2: 0, 3: 2}},
+				"green": {Distribution: map[int]int{0: 0, 1: 0, 2: 0, 3: 0}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Scores(options, 0, 3, tt.ballots)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Scores() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
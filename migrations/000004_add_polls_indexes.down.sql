@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS polls_title_idx;
+DROP INDEX IF EXISTS polls_created_by_idx;
+DROP INDEX IF EXISTS polls_created_at_idx;
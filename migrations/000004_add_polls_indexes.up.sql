@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS polls_title_idx ON polls USING GIN (to_tsvector('simple', title));
+CREATE INDEX IF NOT EXISTS polls_created_by_idx ON polls (created_by);
+CREATE INDEX IF NOT EXISTS polls_created_at_idx ON polls (created_at);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS polls_status_idx;
+ALTER TABLE polls DROP CONSTRAINT IF EXISTS polls_schedule_check;
+ALTER TABLE polls DROP CONSTRAINT IF EXISTS polls_status_check;
+ALTER TABLE polls DROP COLUMN closes_at;
+ALTER TABLE polls DROP COLUMN opens_at;
+ALTER TABLE polls DROP COLUMN status;
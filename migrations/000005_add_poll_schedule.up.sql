@@ -0,0 +1,10 @@
+ALTER TABLE polls ADD COLUMN status text NOT NULL DEFAULT 'open';
+ALTER TABLE polls ADD COLUMN opens_at timestamp(0) with time zone;
+ALTER TABLE polls ADD COLUMN closes_at timestamp(0) with time zone;
+
+ALTER TABLE polls ADD CONSTRAINT polls_status_check
+    CHECK (status IN ('draft', 'scheduled', 'open', 'closed', 'archived'));
+ALTER TABLE polls ADD CONSTRAINT polls_schedule_check
+    CHECK (opens_at IS NULL OR closes_at IS NULL OR closes_at > opens_at);
+
+CREATE INDEX IF NOT EXISTS polls_status_idx ON polls (status);
@@ -0,0 +1,9 @@
+ALTER TABLE votes ADD COLUMN chosen_option text;
+UPDATE votes SET chosen_option = chosen_options[1];
+ALTER TABLE votes ALTER COLUMN chosen_option SET NOT NULL;
+ALTER TABLE votes DROP COLUMN chosen_options;
+
+ALTER TABLE polls DROP CONSTRAINT IF EXISTS polls_choices_check;
+ALTER TABLE polls DROP COLUMN max_choices;
+ALTER TABLE polls DROP COLUMN min_choices;
+ALTER TABLE polls DROP COLUMN voting_mode;
@@ -0,0 +1,11 @@
+ALTER TABLE polls ADD COLUMN voting_mode text NOT NULL DEFAULT 'single';
+ALTER TABLE polls ADD COLUMN min_choices integer NOT NULL DEFAULT 1;
+ALTER TABLE polls ADD COLUMN max_choices integer NOT NULL DEFAULT 1;
+
+ALTER TABLE polls ADD CONSTRAINT polls_choices_check
+    CHECK (min_choices >= 1 AND max_choices >= min_choices);
+
+ALTER TABLE votes ADD COLUMN chosen_options text[];
+UPDATE votes SET chosen_options = ARRAY[chosen_option];
+ALTER TABLE votes ALTER COLUMN chosen_options SET NOT NULL;
+ALTER TABLE votes DROP COLUMN chosen_option;
@@ -0,0 +1,5 @@
+ALTER TABLE votes DROP COLUMN scores;
+
+ALTER TABLE polls DROP CONSTRAINT IF EXISTS polls_score_range_check;
+ALTER TABLE polls DROP COLUMN score_max;
+ALTER TABLE polls DROP COLUMN score_min;
@@ -0,0 +1,7 @@
+ALTER TABLE polls ADD COLUMN score_min integer;
+ALTER TABLE polls ADD COLUMN score_max integer;
+
+ALTER TABLE polls ADD CONSTRAINT polls_score_range_check
+    CHECK (score_min IS NULL OR score_max IS NULL OR score_max > score_min);
+
+ALTER TABLE votes ADD COLUMN scores integer[];
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS vote_history;
+ALTER TABLE polls DROP COLUMN allow_vote_change;
@@ -0,0 +1,19 @@
+ALTER TABLE polls ADD COLUMN allow_vote_change bool NOT NULL DEFAULT false;
+
+-- vote_history is append-only: the application only ever inserts into it. poll_id
+-- and user_id deliberately have no foreign keys so that the history survives the
+-- poll or user being deleted.
+CREATE TABLE IF NOT EXISTS vote_history (
+    id bigserial PRIMARY KEY,
+    poll_id int8 NOT NULL,
+    user_id int8 NOT NULL,
+    action text NOT NULL CHECK (action IN ('cast', 'changed', 'retracted')),
+    chosen_options text[],
+    scores integer[],
+    recorded_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS vote_history_poll_user_idx ON vote_history (poll_id, user_id);
+
+INSERT INTO vote_history (poll_id, user_id, action, chosen_options, scores, recorded_at)
+SELECT poll_id, user_id, 'cast', chosen_options, scores, created_at FROM votes;
@@ -0,0 +1,2 @@
+ALTER TABLE polls DROP CONSTRAINT IF EXISTS polls_results_visibility_check;
+ALTER TABLE polls DROP COLUMN results_visibility;
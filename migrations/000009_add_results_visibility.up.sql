@@ -0,0 +1,4 @@
+ALTER TABLE polls ADD COLUMN results_visibility text NOT NULL DEFAULT 'private';
+
+ALTER TABLE polls ADD CONSTRAINT polls_results_visibility_check
+    CHECK (results_visibility IN ('public', 'after_vote', 'after_close', 'private'));
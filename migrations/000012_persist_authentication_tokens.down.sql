@@ -0,0 +1 @@
+DROP INDEX IF EXISTS tokens_user_id_scope_idx;
@@ -0,0 +1,3 @@
+-- Authentication tokens are now persisted and revoked individually. Tokens issued
+-- before this point carry no jti and are no longer accepted.
+CREATE INDEX IF NOT EXISTS tokens_user_id_scope_idx ON tokens (user_id, scope);
@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN role text DEFAULT 'user';
+
+UPDATE users SET role = 'admin'
+WHERE id IN (
+    SELECT users_roles.user_id FROM users_roles
+    INNER JOIN roles ON roles.id = users_roles.role_id
+    WHERE roles.name = 'admin'
+);
+
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
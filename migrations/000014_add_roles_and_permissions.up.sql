@@ -0,0 +1,49 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL,
+    description text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    granted_by bigint REFERENCES users ON DELETE SET NULL,
+    granted_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Full access to polls, results, users and roles'),
+    ('moderator', 'Creates and manages polls and can read all results'),
+    ('auditor', 'Can read the results of every poll');
+
+INSERT INTO permissions (code) VALUES
+    ('polls:create'),
+    ('polls:update'),
+    ('polls:delete'),
+    ('polls:results:read'),
+    ('users:manage'),
+    ('roles:manage');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin'
+   OR (roles.name = 'moderator' AND permissions.code IN ('polls:create', 'polls:update', 'polls:delete', 'polls:results:read'))
+   OR (roles.name = 'auditor' AND permissions.code = 'polls:results:read');
+
+-- Carry over the single role column, which only ever distinguished admins.
+INSERT INTO users_roles (user_id, role_id)
+SELECT users.id, roles.id FROM users INNER JOIN roles ON roles.name = users.role;
+
+ALTER TABLE users DROP COLUMN role;
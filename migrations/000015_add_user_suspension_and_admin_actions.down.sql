@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS admin_actions;
+
+ALTER TABLE users DROP COLUMN suspended_at;
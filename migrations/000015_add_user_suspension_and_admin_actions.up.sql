@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN suspended_at timestamp(0) with time zone;
+
+-- target_user_id deliberately has no foreign key so that the trail of actions taken
+-- on a user survives the user being deleted.
+CREATE TABLE IF NOT EXISTS admin_actions (
+    id bigserial PRIMARY KEY,
+    admin_id bigint REFERENCES users ON DELETE SET NULL,
+    target_user_id bigint NOT NULL,
+    action text NOT NULL,
+    details jsonb NOT NULL DEFAULT '{}',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS admin_actions_target_user_id_idx ON admin_actions (target_user_id);
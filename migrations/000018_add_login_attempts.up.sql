@@ -0,0 +1,11 @@
+-- key is "email:<address>" for per-account tracking or "ip:<address>" for per-client
+-- tracking. Accounts are keyed by email rather than user id so that unknown email
+-- addresses are locked out exactly like real ones.
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key text PRIMARY KEY,
+    failures integer NOT NULL DEFAULT 0,
+    last_failed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    locked_until timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_locked_until_idx ON login_attempts (locked_until);